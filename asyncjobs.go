@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gcinnovate/integrator/models"
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+)
+
+// asyncJobCheckInterval is how often pending requests are checked for a job due for polling
+const asyncJobCheckInterval = 5 * time.Second
+
+// asyncJobRequestTimeout bounds how long a job poll waits for the server
+const asyncJobRequestTimeout = 30 * time.Second
+
+// pollAsyncJobs follows the import jobs of pending requests every AsyncJobPollInterval
+// and sets the final status of each request from the import summary of its job
+func pollAsyncJobs(db *sqlx.DB, wg *sync.WaitGroup) {
+	defer wg.Done()
+	interval := time.Duration(Dispatcher2Conf.AsyncJobPollInterval) * time.Second
+	for {
+		jobs, err := models.DueAsyncJobs(db, interval)
+		if err != nil {
+			log.WithError(err).Error("Failed to read pending import jobs")
+		}
+		for _, job := range jobs {
+			checkAsyncJob(db, job)
+		}
+		if !sleep(asyncJobCheckInterval) {
+			return
+		}
+	}
+}
+
+// checkAsyncJob polls the job of a pending request and finishes the request once the job is done
+func checkAsyncJob(db *sqlx.DB, job models.AsyncJob) {
+	logger := log.WithFields(log.Fields{"request": job.RequestID, "job": job.ID})
+	timeout := time.Duration(Dispatcher2Conf.AsyncJobTimeout) * time.Second
+	if time.Since(job.Started) > timeout {
+		err := models.FinishAsyncJob(db, job.RequestID, models.RequestStatusError, "ERROR04",
+			fmt.Sprintf("Import job did not finish within %s", timeout), "")
+		if err != nil {
+			logger.WithError(err).Error("Failed to give up import job")
+		}
+		return
+	}
+	checked := func() {
+		if err := models.CheckedAsyncJob(db, job.RequestID); err != nil {
+			logger.WithError(err).Error("Failed to record import job check")
+		}
+	}
+	server, ok := models.LookupServer(int(job.Destination))
+	if !ok {
+		logger.WithField("server", job.Destination).Info("Failed to load server configuration")
+		checked()
+		return
+	}
+	statusURL, err := job.StatusURL(server)
+	if err != nil {
+		logger.WithError(err).Error("Invalid import job URL")
+		checked()
+		return
+	}
+	body, err := getJobResource(server, statusURL)
+	if err != nil {
+		logger.WithError(err).Error("Failed to read import job status")
+		checked()
+		return
+	}
+	completed, errMsg, err := models.JobProgress(body)
+	if err != nil {
+		logger.WithError(err).Error("Failed to read import job status")
+		checked()
+		return
+	}
+	if !completed {
+		checked()
+		return
+	}
+
+	reportURL, err := job.ReportURL(server)
+	if err != nil {
+		logger.WithError(err).Error("Invalid import job URL")
+		checked()
+		return
+	}
+	report, err := getJobResource(server, reportURL)
+	if err != nil {
+		if errMsg != "" { // the job failed without a summary
+			err = models.FinishAsyncJob(db, job.RequestID, models.RequestStatusFailed, models.ImportStatusError, errMsg, "")
+			if err != nil {
+				logger.WithError(err).Error("Failed to finish import job")
+			}
+			return
+		}
+		logger.WithError(err).Error("Failed to read import summary")
+		checked()
+		return
+	}
+	outcome, ok := models.InterpretImportSummary(report)
+	if !ok {
+		logger.Error("Failed to read import summary")
+		outcome = models.ImportOutcome{
+			Status: models.RequestStatusError, StatusCode: "ERROR05", Errors: "Import job returned no import summary"}
+	}
+	err = models.FinishAsyncJob(db, job.RequestID, outcome.Status, outcome.StatusCode, outcome.Errors, string(report))
+	if err != nil {
+		logger.WithError(err).Error("Failed to finish import job")
+		return
+	}
+	logger.WithFields(log.Fields{
+		"status":       outcome.Status,
+		"importStatus": outcome.StatusCode,
+		"importCount":  outcome.Count}).Info("Import job finished")
+}
+
+// getJobResource reads a job resource of server, failing on responses that are not successful
+func getJobResource(server models.Server, url string) ([]byte, error) {
+	client, err := server.HTTPClient()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), asyncJobRequestTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := server.Authenticate(req); err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		server.InvalidateAuth()
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("unexpected response %s", resp.Status)
+	}
+	return body, nil
+}
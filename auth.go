@@ -22,32 +22,57 @@ func BasicAuth() gin.HandlerFunc {
 		payload, _ := base64.StdEncoding.DecodeString(auth[1])
 		pair := strings.SplitN(string(payload), ":", 2)
 
-		if len(pair) != 2 || !AuthenticateUser(pair[0], pair[1]) {
+		if len(pair) != 2 {
+			RespondWithError(401, "Unauthorized", c)
+			return
+		}
+		user, ok := AuthenticateUser(pair[0], pair[1])
+		if !ok {
 			RespondWithError(401, "Unauthorized", c)
 			// c.Writer.Header().Set("WWW-Authenticate", "Basic realm=Restricted")
 			return
 		}
+		c.Set("user", user)
+
+		c.Next()
+	}
+}
 
+// RequirePermission only lets requests through when the role of the authenticated user
+// grants the sys_perms flag on module. It must run after BasicAuth
+func RequirePermission(module, flag string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("user").(models.User)
+		allowed, err := models.HasPermission(db.GetDB(), user.ID, module, flag)
+		if err != nil {
+			log.WithError(err).Error("Failed to check permissions")
+			RespondWithError(500, "Failed to check permissions", c)
+			return
+		}
+		if !allowed {
+			RespondWithError(403, "Forbidden", c)
+			return
+		}
 		c.Next()
 	}
 }
 
-func AuthenticateUser(username, password string) bool {
-	log.Printf("Username:%s, password:%s", username, password)
+func AuthenticateUser(username, password string) (models.User, bool) {
 	userObj := models.User{}
 	err := db.GetDB().QueryRowx(
 		`SELECT
-                        id, username, firstname, lastname , telephone, email
+                        id, user_role, username, firstname, lastname , telephone, COALESCE(email, '') AS email,
+                        is_active, is_system_user
                 FROM users
                 WHERE
-                        username = $1 AND password = crypt($2, password)`,
+                        username = $1 AND password = crypt($2, password) AND is_active`,
 		username, password).StructScan(&userObj)
 	if err != nil {
 		// fmt.Printf("User:[%v]", err)
-		return false
+		return userObj, false
 	}
 	// fmt.Printf("User:[%v]", userObj)
-	return true
+	return userObj, true
 }
 
 func RespondWithError(code int, message string, c *gin.Context) {
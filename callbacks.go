@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gcinnovate/integrator/models"
+	"github.com/gcinnovate/integrator/utils/httpclient"
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+)
+
+// callbackCheckInterval is how often callback deliveries are checked when none were due
+const callbackCheckInterval = 5 * time.Second
+
+// callbackTimeout bounds how long a callback waits for the callback_url
+const callbackTimeout = 30 * time.Second
+
+// callbackBatchSize is the most callback deliveries claimed at once
+const callbackBatchSize = 20
+
+// callbackRetryPolicy is the backoff between attempts to deliver a callback
+var callbackRetryPolicy = models.RetryPolicy{
+	BaseDelay:  time.Minute,
+	MaxDelay:   time.Hour,
+	Multiplier: 2,
+	Jitter:     0.2,
+}
+
+// deliverCallbacks posts the results of requests that reached a final status to the
+// callback_url of their source, retrying failed deliveries up to CallbackMaxAttempts times
+func deliverCallbacks(db *sqlx.DB, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for stopping.Err() == nil {
+		callbacks, err := models.ClaimCallbacks(db, callbackBatchSize, 2*callbackTimeout)
+		if err != nil {
+			log.WithError(err).Error("Failed to claim callbacks")
+		}
+		for _, callback := range callbacks {
+			deliverCallback(db, callback)
+		}
+		if len(callbacks) < callbackBatchSize && !sleep(callbackCheckInterval) {
+			return
+		}
+	}
+}
+
+// deliverCallback posts one callback and records the attempt. Callbacks are delivered when
+// the callback_url responds successfully
+func deliverCallback(db *sqlx.DB, callback models.DueCallback) {
+	logger := log.WithFields(log.Fields{"callback": callback.ID, "request": callback.Payload.UID})
+	responseCode, response, err := postCallback(callback)
+	errMsg := ""
+	if err != nil {
+		errMsg = err.Error()
+	}
+	if err := models.RecordCallbackAttempt(db, callback.ID, responseCode, response, errMsg); err != nil {
+		logger.WithError(err).Error("Failed to record callback attempt")
+	}
+	if err == nil {
+		if err := models.CallbackDeliveredTo(db, callback.ID); err != nil {
+			logger.WithError(err).Error("Failed to record callback delivery")
+		}
+		logger.Info("Callback delivered")
+		return
+	}
+	logger.WithError(err).WithField("attempts", callback.Attempts).Info("Failed to deliver callback")
+	err = models.CallbackNotDelivered(db, callback.ID, callback.Attempts, Dispatcher2Conf.CallbackMaxAttempts,
+		callbackRetryPolicy)
+	if err != nil {
+		logger.WithError(err).Error("Failed to schedule callback")
+	}
+}
+
+// postCallback posts the payload of callback with the credentials and TLS settings of the
+// source it is for. It returns the response code and body, and an error unless the response
+// was successful
+func postCallback(callback models.DueCallback) (*int, string, error) {
+	body, err := json.Marshal(callback.Payload)
+	if err != nil {
+		return nil, "", err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), callbackTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, callback.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := httpclient.Default()
+	if source, ok := models.LookupServer(int(callback.Server)); ok {
+		if err := source.Authenticate(req); err != nil {
+			return nil, "", err
+		}
+		if client, err = source.HTTPClient(); err != nil {
+			return nil, "", err
+		}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	code := resp.StatusCode
+	if code/100 != 2 {
+		return &code, string(respBody), fmt.Errorf("unexpected response %s", resp.Status)
+	}
+	return &code, string(respBody), nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gcinnovate/integrator/models"
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+)
+
+// circuitCheckInterval is how often open circuits are checked for a due probe
+const circuitCheckInterval = 10 * time.Second
+
+// circuitProbeTimeout bounds how long a probe waits for the server
+const circuitProbeTimeout = 30 * time.Second
+
+// probeCircuits periodically probes the servers whose circuit is open and closes the
+// circuit of those that respond again
+func probeCircuits(db *sqlx.DB, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for {
+		servers, err := models.DueCircuitProbes(db)
+		if err != nil {
+			log.WithError(err).Error("Failed to read servers due for a probe")
+		}
+		for _, server := range servers {
+			if !probeServer(server) {
+				log.WithFields(log.Fields{
+					"server": server.ID(),
+					"name":   server.Name()}).Info("Server is still unavailable, circuit stays open")
+				continue
+			}
+			if err := models.CloseCircuit(db, server.ID()); err != nil {
+				log.WithError(err).WithField("server", server.ID()).Error("Failed to close circuit")
+			}
+		}
+		if !sleep(circuitCheckInterval) {
+			return
+		}
+	}
+}
+
+// probeServer returns whether the server responds to its probe URL. DHIS2 servers have to
+// answer the ping successfully, other servers only have to respond without a server error
+func probeServer(server models.Server) bool {
+	client, err := server.HTTPClient()
+	if err != nil {
+		log.WithError(err).WithField("server", server.ID()).Error("Invalid TLS settings")
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), circuitProbeTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.ProbeURL(), nil)
+	if err != nil {
+		log.WithError(err).WithField("server", server.ID()).Error("Invalid probe URL")
+		return false
+	}
+	if err := server.Authenticate(req); err != nil {
+		log.WithError(err).WithField("server", server.ID()).Error("Failed to authenticate probe")
+		return false
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	if strings.EqualFold(server.SystemType(), "DHIS2") {
+		return resp.StatusCode/100 == 2
+	}
+	return !serverFailureStatus(resp.StatusCode)
+}
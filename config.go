@@ -19,4 +19,10 @@ type Dispatcher2Config struct {
 	UseSSL                    string
 	UseGlobalSubmissionPeriod string
 	RequestProcessInterval    int
+	RequestLeaseTime          int // seconds a claimed request is reserved for a worker
+	PriorityAgingInterval     int // seconds a request waits for its priority to rise by one, 0 disables aging
+	AsyncJobPollInterval      int // seconds between checks of an async import job
+	AsyncJobTimeout           int // seconds an async import job may run before its request is given up
+	CallbackMaxAttempts       int // attempts to deliver a callback before it is failed
+	ShutdownTimeout           int // seconds in-flight requests get to finish on shutdown
 }
@@ -0,0 +1,32 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gcinnovate/integrator/models"
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+)
+
+// BatchController defines the batch controller methods
+type BatchController struct{}
+
+// GetBatch method handles the /batches/:batchid GET request
+func (b *BatchController) GetBatch(c *gin.Context) {
+	batchID := c.Param("batchid")
+	db := c.MustGet("dbConn").(*sqlx.DB)
+
+	summary, err := models.GetBatchSummary(db, batchID)
+	if err != nil {
+		if errors.Is(err, models.ErrBatchNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error(), "batchId": batchID})
+			return
+		}
+		log.WithError(err).WithField("batchId", batchID).Error("Failed to read batch summary")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read batch summary"})
+		return
+	}
+	c.JSON(http.StatusOK, summary)
+}
@@ -0,0 +1,90 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gcinnovate/integrator/models"
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+)
+
+// CallbackController defines the callback delivery controller methods
+type CallbackController struct{}
+
+// respondWithCallbackError writes the response for an error returned by the callback models.
+// Unexpected errors are logged and reported as internal errors
+func respondWithCallbackError(c *gin.Context, err error, msg string) {
+	if errors.Is(err, models.ErrCallbackNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	log.WithError(err).Error(msg)
+	c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+}
+
+// Callbacks method handles the /callbacks GET request. Deliveries can be filtered by
+// status, request uid and the server receiving them
+func (cb *CallbackController) Callbacks(c *gin.Context) {
+	db := c.MustGet("dbConn").(*sqlx.DB)
+	filter := models.CallbackFilter{
+		Status:     models.CallbackStatus(c.Query("status")),
+		RequestUID: c.Query("request"),
+	}
+	if filter.Status != "" && !models.ValidCallbackStatus(filter.Status) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid callback status: " + c.Query("status")})
+		return
+	}
+	if server := c.Query("server"); server != "" {
+		id, err := models.LookupServerID(db, server)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		filter.Server = id
+	}
+	page, pageSize := pageParams(c)
+	callbacks, total, err := models.GetCallbacks(db, filter, page, pageSize)
+	if err != nil {
+		respondWithCallbackError(c, err, "Failed to read callbacks")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"page":      page,
+		"pageSize":  pageSize,
+		"total":     total,
+		"callbacks": callbacks})
+}
+
+// GetCallback method handles the /callbacks/:id GET request. The delivery is returned with
+// the log of its attempts
+func (cb *CallbackController) GetCallback(c *gin.Context) {
+	db := c.MustGet("dbConn").(*sqlx.DB)
+	id, ok := idParam(c, "id")
+	if !ok {
+		return
+	}
+	callback, attempts, err := models.GetCallback(db, id)
+	if err != nil {
+		respondWithCallbackError(c, err, "Failed to read callback")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"callback": callback, "attempts": attempts})
+}
+
+// RetryCallback method handles the /callbacks/:id/retry POST request. The delivery is
+// attempted again with a fresh set of attempts
+func (cb *CallbackController) RetryCallback(c *gin.Context) {
+	db := c.MustGet("dbConn").(*sqlx.DB)
+	id, ok := idParam(c, "id")
+	if !ok {
+		return
+	}
+	callback, err := models.RetryCallback(db, id)
+	if err != nil {
+		respondWithCallbackError(c, err, "Failed to retry callback")
+		return
+	}
+	c.JSON(http.StatusOK, callback)
+}
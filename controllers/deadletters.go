@@ -0,0 +1,136 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gcinnovate/integrator/models"
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+)
+
+// DeadLetterController defines the dead letter controller methods
+type DeadLetterController struct{}
+
+// replayParams is the body for replaying dead letters. Dead letters are selected by uids,
+// by destination, statuscode and status, or both
+type replayParams struct {
+	UIDs        []string            `json:"uids"`
+	Destination string              `json:"destination"` // name or id of the destination server
+	StatusCode  *string             `json:"statuscode"`
+	Status      string              `json:"status"`
+	Edits       []models.ReplayEdit `json:"edits"` // bodies to replace before replaying
+}
+
+// deadLetterFilter builds the dead letter filter from the destination, status code and status.
+// It writes a 400 response and returns false when they are not valid
+func deadLetterFilter(c *gin.Context, db *sqlx.DB, destination string, statusCode *string, status string) (models.DeadLetterFilter, bool) {
+	filter := models.DeadLetterFilter{StatusCode: statusCode, Status: models.RequestStatus(status)}
+	if destination != "" {
+		id, err := models.LookupServerID(db, destination)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return filter, false
+		}
+		filter.Destination = id
+	}
+	if status != "" && !models.ValidDeadLetterStatus(filter.Status) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid dead letter status: " + status})
+		return filter, false
+	}
+	return filter, true
+}
+
+// pageParams reads the page and pageSize query parameters, falling back to the first page of 50
+func pageParams(c *gin.Context) (int, int) {
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	pageSize, err := strconv.Atoi(c.DefaultQuery("pageSize", "50"))
+	if err != nil || pageSize < 1 || pageSize > 1000 {
+		pageSize = 50
+	}
+	return page, pageSize
+}
+
+// queryDeadLetterFilter builds the dead letter filter from the query parameters
+func queryDeadLetterFilter(c *gin.Context, db *sqlx.DB) (models.DeadLetterFilter, bool) {
+	var statusCode *string
+	if code, ok := c.GetQuery("statuscode"); ok {
+		statusCode = &code
+	}
+	return deadLetterFilter(c, db, c.Query("destination"), statusCode, c.Query("status"))
+}
+
+// Summary method handles the /deadletters/summary GET request. Dead letters are grouped
+// by destination and status code with the last error and response of each group
+func (d *DeadLetterController) Summary(c *gin.Context) {
+	db := c.MustGet("dbConn").(*sqlx.DB)
+	filter, ok := queryDeadLetterFilter(c, db)
+	if !ok {
+		return
+	}
+	groups, err := models.GetDeadLetterGroups(db, filter)
+	if err != nil {
+		log.WithError(err).Error("Failed to read dead letter summary")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read dead letter summary"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"groups": groups})
+}
+
+// DeadLetters method handles the /deadletters GET request
+func (d *DeadLetterController) DeadLetters(c *gin.Context) {
+	db := c.MustGet("dbConn").(*sqlx.DB)
+	filter, ok := queryDeadLetterFilter(c, db)
+	if !ok {
+		return
+	}
+	page, pageSize := pageParams(c)
+	letters, total, err := models.GetDeadLetters(db, filter, page, pageSize)
+	if err != nil {
+		log.WithError(err).Error("Failed to read dead letters")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read dead letters"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"page":        page,
+		"pageSize":    pageSize,
+		"total":       total,
+		"deadLetters": letters})
+}
+
+// Replay method handles the /deadletters/replay POST request. The selected dead letters are
+// put back into the queue as ready, optionally with edited bodies
+func (d *DeadLetterController) Replay(c *gin.Context) {
+	db := c.MustGet("dbConn").(*sqlx.DB)
+	var params replayParams
+	if err := c.ShouldBindJSON(&params); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	filter, ok := deadLetterFilter(c, db, params.Destination, params.StatusCode, params.Status)
+	if !ok {
+		return
+	}
+	result, err := models.ReplayDeadLetters(db, filter, params.UIDs, params.Edits)
+	if err != nil {
+		var validationErr *models.ValidationError
+		switch {
+		case errors.As(err, &validationErr):
+			c.JSON(http.StatusUnprocessableEntity, gin.H{
+				"error":  "invalid edits",
+				"errors": validationErr.Errors})
+		case errors.Is(err, models.ErrNoReplaySelection):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			log.WithError(err).Error("Failed to replay dead letters")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to replay dead letters"})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
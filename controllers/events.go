@@ -0,0 +1,97 @@
+package controllers
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gcinnovate/integrator/models"
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+)
+
+// eventsKeepAlive is how often a comment is sent to keep idle event streams open
+const eventsKeepAlive = 15 * time.Second
+
+// requestEventFilter selects the request events a client is interested in
+type requestEventFilter struct {
+	source      models.ServerID
+	destination models.ServerID
+	batchID     string
+	uids        map[string]bool
+}
+
+func (f *requestEventFilter) matches(ev models.RequestEvent) bool {
+	if f.source != 0 && models.ServerID(ev.Source) != f.source {
+		return false
+	}
+	if f.destination != 0 && models.ServerID(ev.Destination) != f.destination {
+		return false
+	}
+	if f.batchID != "" && ev.BatchID != f.batchID {
+		return false
+	}
+	if len(f.uids) > 0 && !f.uids[ev.UID] {
+		return false
+	}
+	return true
+}
+
+// StatusEvents method handles the /queue/events GET request. Status changes of requests
+// are streamed as Server-Sent Events, optionally filtered by source, destination, batchid or uid
+func (q *QueueController) StatusEvents(c *gin.Context) {
+	db := c.MustGet("dbConn").(*sqlx.DB)
+
+	filter := requestEventFilter{batchID: c.Query("batchid"), uids: map[string]bool{}}
+	for _, p := range []struct {
+		name string
+		id   *models.ServerID
+	}{{"source", &filter.source}, {"destination", &filter.destination}} {
+		ref := c.Query(p.name)
+		if ref == "" {
+			continue
+		}
+		id, err := models.LookupServerID(db, ref)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		*p.id = id
+	}
+	for _, uids := range c.QueryArray("uid") {
+		for _, uid := range strings.Split(uids, ",") {
+			if uid = strings.TrimSpace(uid); uid != "" {
+				filter.uids[uid] = true
+			}
+		}
+	}
+
+	events, unsubscribe := models.SubscribeRequestEvents()
+	defer unsubscribe()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+
+	keepAlive := time.NewTicker(eventsKeepAlive)
+	defer keepAlive.Stop()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case ev, ok := <-events:
+			if !ok {
+				return false
+			}
+			if filter.matches(ev) {
+				c.SSEvent("status", ev)
+			}
+			return true
+		case <-keepAlive.C:
+			_, err := io.WriteString(w, ": keep-alive\n\n")
+			return err == nil
+		}
+	})
+}
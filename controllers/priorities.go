@@ -0,0 +1,70 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gcinnovate/integrator/models"
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+)
+
+// PriorityController defines the request priority controller methods
+type PriorityController struct{}
+
+// priorityParams is the body for setting the default priority of a report type
+type priorityParams struct {
+	Priority *int `json:"priority" binding:"required"`
+}
+
+// ReportTypePriorities method handles the /priorities/report-types GET request
+func (p *PriorityController) ReportTypePriorities(c *gin.Context) {
+	db := c.MustGet("dbConn").(*sqlx.DB)
+	priorities, err := models.GetReportTypePriorities(db)
+	if err != nil {
+		log.WithError(err).Error("Failed to read report type priorities")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read report type priorities"})
+		return
+	}
+	c.JSON(http.StatusOK, priorities)
+}
+
+// SetReportTypePriority method handles the /priorities/report-types/:reportType PUT request
+func (p *PriorityController) SetReportTypePriority(c *gin.Context) {
+	db := c.MustGet("dbConn").(*sqlx.DB)
+	var params priorityParams
+	if err := c.ShouldBindJSON(&params); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	priority, err := models.SetReportTypePriority(db, c.Param("reportType"), *params.Priority)
+	if err != nil {
+		var validationErr *models.ValidationError
+		if errors.As(err, &validationErr) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{
+				"error": "invalid report type priority", "errors": validationErr.Errors})
+			return
+		}
+		log.WithError(err).Error("Failed to set report type priority")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set report type priority"})
+		return
+	}
+	c.JSON(http.StatusOK, priority)
+}
+
+// DeleteReportTypePriority method handles the /priorities/report-types/:reportType DELETE request
+func (p *PriorityController) DeleteReportTypePriority(c *gin.Context) {
+	db := c.MustGet("dbConn").(*sqlx.DB)
+	err := models.DeleteReportTypePriority(db, c.Param("reportType"))
+	if errors.Is(err, models.ErrReportTypePriorityNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.WithError(err).Error("Failed to delete report type priority")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete report type priority"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "deleted"})
+}
@@ -1,9 +1,12 @@
 package controllers
 
 import (
+	"bytes"
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 
 	"database/sql/driver"
@@ -47,28 +50,184 @@ func (q *QueueController) Queue(c *gin.Context) {
 	contentType := c.Request.Header.Get("Content-Type")
 	req, err := models.NewRequest(c, db)
 	if err != nil {
+		if respondWithRequestError(c, err) {
+			return
+		}
 		log.WithError(err).Error("Failed to add request to queue")
 		c.String(http.StatusBadGateway, "Failed to add request to queue")
 		return
 	}
 
 	fmt.Printf("cType %s", contentType)
+	if req.Deduplicated() {
+		log.WithFields(log.Fields{
+			"uid":          req.UID(),
+			"source":       req.Source(),
+			"submissionId": req.SubmissionID(),
+		}).Info("Request already queued, returning original")
+		c.Header("Idempotent-Replayed", "true")
+	}
 	c.JSON(http.StatusOK, gin.H{
-		"uid":         req.UID(),
+		"uid":          req.UID(),
+		"source":       req.Source(),
+		"destination":  req.Destination(),
+		"body":         req.Body(),
+		"status":       req.Status(),
+		"RawMsg":       req.RawMsg(),
+		"period":       req.Period(),
+		"priority":     req.Priority(),
+		"submissionId": req.SubmissionID(),
+		"created":      !req.Deduplicated(),
+		"deduplicated": req.Deduplicated()})
+	return
+}
+
+// ValidateQueue method handles the /queue/validate request. It runs the same checks as
+// /queue without adding the request to the queue
+func (q *QueueController) ValidateQueue(c *gin.Context) {
+	db := c.MustGet("dbConn").(*sqlx.DB)
+
+	req, err := models.ValidateRequest(c, db)
+	if err != nil {
+		if respondWithRequestError(c, err) {
+			return
+		}
+		log.WithError(err).Error("Failed to validate request")
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"valid":       true,
 		"source":      req.Source(),
 		"destination": req.Destination(),
-		"body":        req.Body(),
-		"status":      req.Status(),
-		"RawMsg":      req.RawMsg(),
-		"period":      req.Period()})
-	return
+		"objectType":  req.ObjectType(),
+		"contentType": req.ContentType()})
+}
+
+// respondWithRequestError writes the response for route and body validation errors. A source
+// missing from the allowed sources of the destination gets 403 Forbidden. It returns false
+// when err is neither, leaving the response to the caller
+func respondWithRequestError(c *gin.Context, err error) bool {
+	var validationErr *models.ValidationError
+	switch {
+	case errors.As(err, &validationErr):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":      err.Error(),
+			"objectType": validationErr.ObjectType,
+			"errors":     validationErr.Errors})
+	case errors.Is(err, models.ErrSourceNotAllowed):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case models.IsRouteError(err):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		return false
+	}
+	return true
+}
+
+// maxBulkRequests is the largest number of envelopes accepted in one bulk submission
+const maxBulkRequests = 10000
+
+// BulkQueue method handles the /queue/bulk request. The body is either a JSON array of
+// request envelopes or newline delimited JSON with one envelope per line
+func (q *QueueController) BulkQueue(c *gin.Context) {
+	db := c.MustGet("dbConn").(*sqlx.DB)
+
+	raw, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+		return
+	}
+	items, err := splitBulkBody(raw)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(items) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No requests found in body"})
+		return
+	}
+	if len(items) > maxBulkRequests {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"error": fmt.Sprintf("At most %d requests can be queued at once", maxBulkRequests)})
+		return
+	}
+
+	results := make([]models.BulkRequestResult, len(items))
+	var envelopes []models.RequestEnvelope
+	var positions []int // index in items of each decoded envelope
+	for i, item := range items {
+		results[i].Index = i
+		envelope := models.RequestEnvelope{}
+		if err := json.Unmarshal(item, &envelope); err != nil {
+			results[i].Errors = []string{fmt.Sprintf("invalid request envelope: %v", err)}
+			continue
+		}
+		envelopes = append(envelopes, envelope)
+		positions = append(positions, i)
+	}
+
+	queuedResults, err := models.NewRequests(db, envelopes)
+	if err != nil {
+		log.WithError(err).Error("Failed to add bulk requests to queue")
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to add requests to queue"})
+		return
+	}
+	for j, result := range queuedResults {
+		result.Index = positions[j]
+		results[positions[j]] = result
+	}
+
+	var queued, deduplicated, failed int
+	for _, result := range results {
+		switch {
+		case len(result.Errors) > 0:
+			failed++
+		case result.Deduplicated:
+			deduplicated++
+		default:
+			queued++
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"total":        len(results),
+		"queued":       queued,
+		"deduplicated": deduplicated,
+		"failed":       failed,
+		"results":      results})
+}
+
+// splitBulkBody splits a JSON array or NDJSON body into the raw envelopes it contains
+func splitBulkBody(raw []byte) ([]json.RawMessage, error) {
+	trimmed := bytes.TrimSpace(raw)
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		var items []json.RawMessage
+		if err := json.Unmarshal(trimmed, &items); err != nil {
+			return nil, fmt.Errorf("invalid JSON array: %v", err)
+		}
+		return items, nil
+	}
+	var items []json.RawMessage
+	dec := json.NewDecoder(bytes.NewReader(trimmed))
+	for line := 1; ; line++ {
+		var item json.RawMessage
+		err := dec.Decode(&item)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid NDJSON at record %d: %v", line, err)
+		}
+		items = append(items, item)
+	}
+	return items, nil
 }
 
 var requestFields = []string{
-	"uid", "source", "destination", "ctype", "body", "response", "status", "statuscode",
+	"uid", "batchid", "source", "destination", "ctype", "body", "response", "status", "statuscode",
 	"retries", "errors", "frequency_type", "period", "day", "week", "month", "year",
-	"msisdn", "raw_msg", "facility", "district", "report_type", "extras", "suspended",
-	"body_is_query_param", "submissionid", "url_suffix", "created", "updated", "*"}
+	"msisdn", "raw_msg", "facility", "district", "report_type", "object_type", "extras", "suspended",
+	"body_is_query_param", "submissionid", "url_suffix", "next_attempt_at", "priority", "job_id", "parent_id", "cc_url", "created", "updated", "*"}
 
 // Requests method handles the /queque GET request
 func (q *QueueController) Requests(c *gin.Context) {
@@ -97,23 +256,27 @@ FROM %s
 		fields = append(fields, dbutil.Field{f, "r", ""})
 	}
 
-	qbuild.Conditions = dbutil.QueryFiltersToConditions(filters, "r")
+	conditions, err := dbutil.QueryFiltersToConditions(filters, requestFields, "r")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	qbuild.Conditions = conditions
 	qbuild.Fields = fields
 	qbuild.OrderBy = dbutil.OrderListToOrderBy(orderbys, requestFields, "r")
 
-	var whereClause string
-	if len(qbuild.Conditions) == 0 {
-		whereClause = " TRUE"
-	} else {
-		whereClause = fmt.Sprintf("%s", dbutil.QueryConditions(qbuild.Conditions))
+	whereClause, countArgs := " TRUE", []interface{}{}
+	if len(qbuild.Conditions) > 0 {
+		whereClause, countArgs = dbutil.QueryConditions(qbuild.Conditions, nil)
 	}
 	countquery := fmt.Sprintf("SELECT COUNT(*) AS count FROM requests r WHERE %s", whereClause)
 
 	db := c.MustGet("dbConn").(*sqlx.DB)
 	var count int64
-	err := db.Get(&count, countquery)
+	err = db.Get(&count, countquery, countArgs...)
 	if err != nil {
-		fmt.Println(">>>>>>>>>>>>>>>>>>>>>", err)
+		log.WithError(err).Error("Failed to count requests")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count requests"})
 		return
 	}
 
@@ -126,11 +289,12 @@ FROM %s
 	qbuild.Limit = p.PageSize
 	qbuild.Offset = p.FirstItem() - 1
 
-	jsonquery := fmt.Sprintf("SELECT ROW_TO_JSON(s) FROM (%s) s;", qbuild.ToSQL(shouldWePage))
+	query, args := qbuild.ToSQL(shouldWePage)
+	jsonquery := fmt.Sprintf("SELECT ROW_TO_JSON(s) FROM (%s) s;", query)
 
 	var requests []Reqs
 
-	err = db.Select(&requests, jsonquery)
+	err = db.Select(&requests, jsonquery, args...)
 	if err != nil {
 		log.WithError(err).Error("Failed to query request")
 	}
@@ -153,7 +317,6 @@ FROM %s
 func (q *QueueController) GetRequest(c *gin.Context) {
 	uid := (c.Param("id"))
 	qfields := c.DefaultQuery("fields", "uid,source,destination,body,status")
-	filters := []string{"uid:EQ:" + uid}
 
 	requestsTable := dbutil.Table{"requests", "r"}
 	// change _ to relationships and handle them
@@ -168,17 +331,23 @@ FROM %s
 	for _, f := range filtered {
 		fields = append(fields, dbutil.Field{f, "r", ""})
 	}
-	qbuild.Conditions = dbutil.QueryFiltersToConditions(filters, "r")
+	qbuild.Conditions = []dbutil.Condition{{
+		Field: dbutil.Field{Name: "uid", TablePrefix: "r"}, Operator: "=", Values: []interface{}{uid}}}
 	qbuild.Fields = fields
 
+	query, args := qbuild.ToSQL(false)
 	jsonquery := fmt.Sprintf(`
-SELECT ROW_TO_JSON(s) FROM (%s) s;`, qbuild.ToSQL(false))
+SELECT ROW_TO_JSON(s) FROM (%s) s;`, query)
 
 	db := c.MustGet("dbConn").(*sqlx.DB)
 	var request Reqs
 
-	err := db.Get(&request, jsonquery)
+	err := db.Get(&request, jsonquery, args...)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": models.ErrRequestNotFound.Error()})
+			return
+		}
 		log.WithError(err).Error("Failed to query request:" + jsonquery)
 	}
 	c.JSON(http.StatusOK, request)
@@ -190,14 +359,68 @@ func (q *QueueController) DeleteRequest(c *gin.Context) {
 	uid := c.Param("id")
 	db := c.MustGet("dbConn").(*sqlx.DB)
 
-	query := fmt.Sprintf("DELETE FROM requests WHERE uid = '%s'", uid)
-	_, err := db.Query(query)
+	result, err := db.Exec("DELETE FROM requests WHERE uid = $1", uid)
 	if err != nil {
 		log.WithError(err).Error("Failed to delete request:")
 		c.JSON(http.StatusConflict, gin.H{"status": "failed to delete"})
 		return
 	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": models.ErrRequestNotFound.Error()})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{
 		"status": "deleted"})
 	return
 }
+
+// RetryRequest method handles the /queue/:id/retry POST request
+func (q *QueueController) RetryRequest(c *gin.Context) {
+	q.applyAction(c, models.RequestActionRetry)
+}
+
+// RequeueRequest method handles the /queue/:id/requeue POST request
+func (q *QueueController) RequeueRequest(c *gin.Context) {
+	q.applyAction(c, models.RequestActionRequeue)
+}
+
+// CancelRequest method handles the /queue/:id/cancel POST request
+func (q *QueueController) CancelRequest(c *gin.Context) {
+	q.applyAction(c, models.RequestActionCancel)
+}
+
+// SuspendRequest method handles the /queue/:id/suspend POST request
+func (q *QueueController) SuspendRequest(c *gin.Context) {
+	q.applyAction(c, models.RequestActionSuspend)
+}
+
+// UnsuspendRequest method handles the /queue/:id/unsuspend POST request
+func (q *QueueController) UnsuspendRequest(c *gin.Context) {
+	q.applyAction(c, models.RequestActionUnsuspend)
+}
+
+// applyAction performs a state transition on the request and reports the outcome
+func (q *QueueController) applyAction(c *gin.Context, action models.RequestAction) {
+	uid := c.Param("id")
+	db := c.MustGet("dbConn").(*sqlx.DB)
+
+	status, err := models.ApplyRequestAction(db, uid, action)
+	if err != nil {
+		var transitionErr *models.TransitionError
+		switch {
+		case errors.Is(err, models.ErrRequestNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.As(err, &transitionErr):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "status": status})
+		default:
+			log.WithError(err).WithField("uid", uid).Error("Failed to " + string(action) + " request")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to " + string(action) + " request"})
+		}
+		return
+	}
+	log.WithFields(log.Fields{"uid": uid, "action": action, "status": status}).Info("Request action applied")
+	c.JSON(http.StatusOK, gin.H{
+		"uid":    uid,
+		"action": action,
+		"status": status})
+}
@@ -0,0 +1,280 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gcinnovate/integrator/models"
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+)
+
+// ServerController defines the server/app controller methods
+type ServerController struct{}
+
+// allowedSourcesParams is the body for replacing the allowed sources of a server
+type allowedSourcesParams struct {
+	Sources []string `json:"sources" binding:"required"` // names or ids of the source servers
+}
+
+// respondWithServerError writes the response for an error returned by the server models.
+// Unexpected errors are logged and reported as internal errors
+func respondWithServerError(c *gin.Context, err error, msg string) {
+	var validationErr *models.ValidationError
+	switch {
+	case errors.As(err, &validationErr):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":  "invalid server configuration",
+			"errors": validationErr.Errors})
+	case errors.Is(err, models.ErrServerNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, models.ErrServerInUse):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		log.WithError(err).Error(msg)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+	}
+}
+
+// serverParam resolves the server referenced by the named route parameter.
+// It writes a 404 response and returns false when the server does not exist
+func serverParam(c *gin.Context, db *sqlx.DB, name string) (models.ServerID, bool) {
+	id, err := models.LookupServerID(db, c.Param(name))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return 0, false
+	}
+	return id, true
+}
+
+// respondWithSources writes the current allowed sources of destination
+func respondWithSources(c *gin.Context, db *sqlx.DB, destination models.ServerID) {
+	sources, err := models.GetAllowedSources(db, destination)
+	if err != nil {
+		log.WithError(err).Error("Failed to read allowed sources")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read allowed sources"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"server":  destination,
+		"sources": sources})
+}
+
+// AllowedSources method handles the /servers/:id/sources GET request. Only the listed sources
+// may queue requests for the server, a server without allowed sources accepts no requests
+func (s *ServerController) AllowedSources(c *gin.Context) {
+	db := c.MustGet("dbConn").(*sqlx.DB)
+	destination, ok := serverParam(c, db, "id")
+	if !ok {
+		return
+	}
+	respondWithSources(c, db, destination)
+}
+
+// SetAllowedSources method handles the /servers/:id/sources PUT request
+func (s *ServerController) SetAllowedSources(c *gin.Context) {
+	db := c.MustGet("dbConn").(*sqlx.DB)
+	destination, ok := serverParam(c, db, "id")
+	if !ok {
+		return
+	}
+	var params allowedSourcesParams
+	if err := c.ShouldBindJSON(&params); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	sources := make([]models.ServerID, 0, len(params.Sources))
+	for _, ref := range params.Sources {
+		id, err := models.LookupServerID(db, ref)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		sources = append(sources, id)
+	}
+	if err := models.SetAllowedSources(db, destination, sources); err != nil {
+		if errors.Is(err, models.ErrSameSourceAndDestination) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		log.WithError(err).Error("Failed to set allowed sources")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set allowed sources"})
+		return
+	}
+	respondWithSources(c, db, destination)
+}
+
+// AddAllowedSource method handles the /servers/:id/sources/:source POST request
+func (s *ServerController) AddAllowedSource(c *gin.Context) {
+	db := c.MustGet("dbConn").(*sqlx.DB)
+	destination, ok := serverParam(c, db, "id")
+	if !ok {
+		return
+	}
+	source, ok := serverParam(c, db, "source")
+	if !ok {
+		return
+	}
+	if err := models.AddAllowedSource(db, destination, source); err != nil {
+		if errors.Is(err, models.ErrSameSourceAndDestination) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		log.WithError(err).Error("Failed to add allowed source")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add allowed source"})
+		return
+	}
+	respondWithSources(c, db, destination)
+}
+
+// RemoveAllowedSource method handles the /servers/:id/sources/:source DELETE request
+func (s *ServerController) RemoveAllowedSource(c *gin.Context) {
+	db := c.MustGet("dbConn").(*sqlx.DB)
+	destination, ok := serverParam(c, db, "id")
+	if !ok {
+		return
+	}
+	source, ok := serverParam(c, db, "source")
+	if !ok {
+		return
+	}
+	if err := models.RemoveAllowedSource(db, destination, source); err != nil {
+		log.WithError(err).Error("Failed to remove allowed source")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove allowed source"})
+		return
+	}
+	respondWithSources(c, db, destination)
+}
+
+// Servers method handles the /servers GET request
+func (s *ServerController) Servers(c *gin.Context) {
+	db := c.MustGet("dbConn").(*sqlx.DB)
+	servers, err := models.GetServers(db)
+	if err != nil {
+		respondWithServerError(c, err, "Failed to read servers")
+		return
+	}
+	c.JSON(http.StatusOK, servers)
+}
+
+// GetServer method handles the /servers/:id GET request
+func (s *ServerController) GetServer(c *gin.Context) {
+	db := c.MustGet("dbConn").(*sqlx.DB)
+	id, ok := serverParam(c, db, "id")
+	if !ok {
+		return
+	}
+	server, err := models.GetServer(db, id)
+	if err != nil {
+		respondWithServerError(c, err, "Failed to read server")
+		return
+	}
+	c.JSON(http.StatusOK, server)
+}
+
+// CreateServer method handles the /servers POST request
+func (s *ServerController) CreateServer(c *gin.Context) {
+	db := c.MustGet("dbConn").(*sqlx.DB)
+	var params models.ServerParams
+	if err := c.ShouldBindJSON(&params); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	server, err := models.CreateServer(db, params)
+	if err != nil {
+		respondWithServerError(c, err, "Failed to create server")
+		return
+	}
+	c.JSON(http.StatusCreated, server)
+}
+
+// UpdateServer method handles the /servers/:id PUT and PATCH requests. Only the fields
+// present in the body are changed
+func (s *ServerController) UpdateServer(c *gin.Context) {
+	db := c.MustGet("dbConn").(*sqlx.DB)
+	id, ok := serverParam(c, db, "id")
+	if !ok {
+		return
+	}
+	var params models.ServerParams
+	if err := c.ShouldBindJSON(&params); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	server, err := models.UpdateServer(db, id, params)
+	if err != nil {
+		respondWithServerError(c, err, "Failed to update server")
+		return
+	}
+	c.JSON(http.StatusOK, server)
+}
+
+// DeleteServer method handles the /servers/:id DELETE request
+func (s *ServerController) DeleteServer(c *gin.Context) {
+	db := c.MustGet("dbConn").(*sqlx.DB)
+	id, ok := serverParam(c, db, "id")
+	if !ok {
+		return
+	}
+	if err := models.DeleteServer(db, id); err != nil {
+		respondWithServerError(c, err, "Failed to delete server")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "deleted"})
+}
+
+// respondWithCircuit writes the circuit breaker state of the server
+func respondWithCircuit(c *gin.Context, db *sqlx.DB, id models.ServerID) {
+	server, err := models.GetServer(db, id)
+	if err != nil {
+		respondWithServerError(c, err, "Failed to read server")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"server":           id,
+		"state":            server.CircuitState(),
+		"failures":         server.CircuitFailures(),
+		"failureThreshold": server.CircuitFailureThreshold(),
+		"openedAt":         server.CircuitOpenedAt(),
+		"probedAt":         server.CircuitProbedAt(),
+		"probeURL":         server.ProbeURL()})
+}
+
+// Circuit method handles the /servers/:id/circuit GET request
+func (s *ServerController) Circuit(c *gin.Context) {
+	db := c.MustGet("dbConn").(*sqlx.DB)
+	id, ok := serverParam(c, db, "id")
+	if !ok {
+		return
+	}
+	respondWithCircuit(c, db, id)
+}
+
+// OpenCircuit method handles the /servers/:id/circuit/open POST request
+func (s *ServerController) OpenCircuit(c *gin.Context) {
+	db := c.MustGet("dbConn").(*sqlx.DB)
+	id, ok := serverParam(c, db, "id")
+	if !ok {
+		return
+	}
+	if err := models.OpenCircuit(db, id); err != nil {
+		respondWithServerError(c, err, "Failed to open circuit")
+		return
+	}
+	respondWithCircuit(c, db, id)
+}
+
+// CloseCircuit method handles the /servers/:id/circuit/close POST request
+func (s *ServerController) CloseCircuit(c *gin.Context) {
+	db := c.MustGet("dbConn").(*sqlx.DB)
+	id, ok := serverParam(c, db, "id")
+	if !ok {
+		return
+	}
+	if err := models.CloseCircuit(db, id); err != nil {
+		respondWithServerError(c, err, "Failed to close circuit")
+		return
+	}
+	respondWithCircuit(c, db, id)
+}
@@ -0,0 +1,305 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gcinnovate/integrator/models"
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+)
+
+// UserController defines the user controller methods
+type UserController struct{}
+
+// RoleController defines the user role controller methods
+type RoleController struct{}
+
+// passwordParams is the body for changing the password of a user
+type passwordParams struct {
+	Password string `json:"password" binding:"required"`
+}
+
+// permissionsParams is the body for replacing the permissions of a role
+type permissionsParams struct {
+	Permissions []models.Permission `json:"permissions" binding:"required"`
+}
+
+// idParam parses the named numeric route parameter, writing a 400 response when it is not a number
+func idParam(c *gin.Context, name string) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param(name), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + name + ": " + c.Param(name)})
+		return 0, false
+	}
+	return id, true
+}
+
+// respondWithUserError writes the response for an error returned by the user and role models.
+// Unexpected errors are logged and reported as internal errors
+func respondWithUserError(c *gin.Context, err error, msg string) {
+	var validationErr *models.ValidationError
+	switch {
+	case errors.As(err, &validationErr):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":  "invalid " + validationErrorSubject(c),
+			"errors": validationErr.Errors})
+	case errors.Is(err, models.ErrUserNotFound), errors.Is(err, models.ErrRoleNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, models.ErrSystemUser), errors.Is(err, models.ErrSystemUserPassword):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, models.ErrUserInUse), errors.Is(err, models.ErrRoleInUse):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		log.WithError(err).Error(msg)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+	}
+}
+
+// validationErrorSubject names what was being validated from the route of the request
+func validationErrorSubject(c *gin.Context) string {
+	switch c.FullPath() {
+	case "/api/roles", "/api/roles/:id":
+		return "role"
+	case "/api/roles/:id/permissions":
+		return "permissions"
+	case "/api/users/:id/password":
+		return "password"
+	}
+	return "user"
+}
+
+// Users method handles the /users GET request
+func (u *UserController) Users(c *gin.Context) {
+	db := c.MustGet("dbConn").(*sqlx.DB)
+	users, err := models.GetUsers(db)
+	if err != nil {
+		respondWithUserError(c, err, "Failed to read users")
+		return
+	}
+	c.JSON(http.StatusOK, users)
+}
+
+// GetUser method handles the /users/:id GET request
+func (u *UserController) GetUser(c *gin.Context) {
+	db := c.MustGet("dbConn").(*sqlx.DB)
+	id, ok := idParam(c, "id")
+	if !ok {
+		return
+	}
+	user, err := models.GetUser(db, id)
+	if err != nil {
+		respondWithUserError(c, err, "Failed to read user")
+		return
+	}
+	c.JSON(http.StatusOK, user)
+}
+
+// CreateUser method handles the /users POST request
+func (u *UserController) CreateUser(c *gin.Context) {
+	db := c.MustGet("dbConn").(*sqlx.DB)
+	var params models.UserParams
+	if err := c.ShouldBindJSON(&params); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	user, err := models.CreateUser(db, params)
+	if err != nil {
+		respondWithUserError(c, err, "Failed to create user")
+		return
+	}
+	c.JSON(http.StatusCreated, user)
+}
+
+// UpdateUser method handles the /users/:id PUT and PATCH requests. Only the fields
+// present in the body are changed
+func (u *UserController) UpdateUser(c *gin.Context) {
+	db := c.MustGet("dbConn").(*sqlx.DB)
+	id, ok := idParam(c, "id")
+	if !ok {
+		return
+	}
+	var params models.UserParams
+	if err := c.ShouldBindJSON(&params); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	user, err := models.UpdateUser(db, id, params)
+	if err != nil {
+		respondWithUserError(c, err, "Failed to update user")
+		return
+	}
+	c.JSON(http.StatusOK, user)
+}
+
+// ChangePassword method handles the /users/:id/password PUT request. The password of a
+// system user can only be changed by that user
+func (u *UserController) ChangePassword(c *gin.Context) {
+	db := c.MustGet("dbConn").(*sqlx.DB)
+	id, ok := idParam(c, "id")
+	if !ok {
+		return
+	}
+	var params passwordParams
+	if err := c.ShouldBindJSON(&params); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	caller := c.MustGet("user").(models.User)
+	if err := models.ChangeUserPassword(db, id, caller.ID, params.Password); err != nil {
+		respondWithUserError(c, err, "Failed to change password")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "password changed"})
+}
+
+// ActivateUser method handles the /users/:id/activate POST request
+func (u *UserController) ActivateUser(c *gin.Context) {
+	setUserActive(c, true)
+}
+
+// DeactivateUser method handles the /users/:id/deactivate POST request
+func (u *UserController) DeactivateUser(c *gin.Context) {
+	setUserActive(c, false)
+}
+
+func setUserActive(c *gin.Context, active bool) {
+	db := c.MustGet("dbConn").(*sqlx.DB)
+	id, ok := idParam(c, "id")
+	if !ok {
+		return
+	}
+	user, err := models.SetUserActive(db, id, active)
+	if err != nil {
+		respondWithUserError(c, err, "Failed to update user")
+		return
+	}
+	c.JSON(http.StatusOK, user)
+}
+
+// DeleteUser method handles the /users/:id DELETE request
+func (u *UserController) DeleteUser(c *gin.Context) {
+	db := c.MustGet("dbConn").(*sqlx.DB)
+	id, ok := idParam(c, "id")
+	if !ok {
+		return
+	}
+	if err := models.DeleteUser(db, id); err != nil {
+		respondWithUserError(c, err, "Failed to delete user")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "deleted"})
+}
+
+// Roles method handles the /roles GET request
+func (r *RoleController) Roles(c *gin.Context) {
+	db := c.MustGet("dbConn").(*sqlx.DB)
+	roles, err := models.GetRoles(db)
+	if err != nil {
+		respondWithUserError(c, err, "Failed to read roles")
+		return
+	}
+	c.JSON(http.StatusOK, roles)
+}
+
+// GetRole method handles the /roles/:id GET request
+func (r *RoleController) GetRole(c *gin.Context) {
+	db := c.MustGet("dbConn").(*sqlx.DB)
+	id, ok := idParam(c, "id")
+	if !ok {
+		return
+	}
+	role, err := models.GetRole(db, id)
+	if err != nil {
+		respondWithUserError(c, err, "Failed to read role")
+		return
+	}
+	c.JSON(http.StatusOK, role)
+}
+
+// CreateRole method handles the /roles POST request
+func (r *RoleController) CreateRole(c *gin.Context) {
+	db := c.MustGet("dbConn").(*sqlx.DB)
+	var params models.RoleParams
+	if err := c.ShouldBindJSON(&params); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	role, err := models.CreateRole(db, params)
+	if err != nil {
+		respondWithUserError(c, err, "Failed to create role")
+		return
+	}
+	c.JSON(http.StatusCreated, role)
+}
+
+// UpdateRole method handles the /roles/:id PUT and PATCH requests
+func (r *RoleController) UpdateRole(c *gin.Context) {
+	db := c.MustGet("dbConn").(*sqlx.DB)
+	id, ok := idParam(c, "id")
+	if !ok {
+		return
+	}
+	var params models.RoleParams
+	if err := c.ShouldBindJSON(&params); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	role, err := models.UpdateRole(db, id, params)
+	if err != nil {
+		respondWithUserError(c, err, "Failed to update role")
+		return
+	}
+	c.JSON(http.StatusOK, role)
+}
+
+// DeleteRole method handles the /roles/:id DELETE request
+func (r *RoleController) DeleteRole(c *gin.Context) {
+	db := c.MustGet("dbConn").(*sqlx.DB)
+	id, ok := idParam(c, "id")
+	if !ok {
+		return
+	}
+	if err := models.DeleteRole(db, id); err != nil {
+		respondWithUserError(c, err, "Failed to delete role")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "deleted"})
+}
+
+// Permissions method handles the /roles/:id/permissions GET request
+func (r *RoleController) Permissions(c *gin.Context) {
+	db := c.MustGet("dbConn").(*sqlx.DB)
+	id, ok := idParam(c, "id")
+	if !ok {
+		return
+	}
+	perms, err := models.GetRolePermissions(db, id)
+	if err != nil {
+		respondWithUserError(c, err, "Failed to read permissions")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"role": id, "permissions": perms})
+}
+
+// SetPermissions method handles the /roles/:id/permissions PUT request
+func (r *RoleController) SetPermissions(c *gin.Context) {
+	db := c.MustGet("dbConn").(*sqlx.DB)
+	id, ok := idParam(c, "id")
+	if !ok {
+		return
+	}
+	var params permissionsParams
+	if err := c.ShouldBindJSON(&params); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	perms, err := models.SetRolePermissions(db, id, params.Permissions)
+	if err != nil {
+		respondWithUserError(c, err, "Failed to set permissions")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"role": id, "permissions": perms})
+}
@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS requests_source_submissionid;
+
+ALTER TABLE requests ALTER COLUMN submissionid DROP DEFAULT;
+ALTER TABLE requests ALTER COLUMN submissionid TYPE INTEGER
+    USING CASE WHEN submissionid ~ '^[0-9]+$' THEN submissionid::INTEGER ELSE 0 END;
+ALTER TABLE requests ALTER COLUMN submissionid SET DEFAULT 0;
+
+CREATE INDEX requests_submissionid ON requests(submissionid);
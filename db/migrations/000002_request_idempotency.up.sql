@@ -0,0 +1,16 @@
+-- submissionid holds the idempotency key sent by the source (msgid or Idempotency-Key header)
+-- these are not always numeric so we store them as text
+ALTER TABLE requests ALTER COLUMN submissionid DROP DEFAULT;
+ALTER TABLE requests ALTER COLUMN submissionid TYPE TEXT
+    USING CASE WHEN submissionid = 0 THEN '' ELSE submissionid::TEXT END;
+ALTER TABLE requests ALTER COLUMN submissionid SET DEFAULT '';
+
+-- keep only the first request for any duplicated key before enforcing uniqueness
+UPDATE requests r SET submissionid = ''
+WHERE submissionid <> '' AND EXISTS (
+    SELECT 1 FROM requests o
+    WHERE o.source = r.source AND o.submissionid = r.submissionid AND o.id < r.id);
+
+DROP INDEX IF EXISTS requests_submissionid;
+CREATE UNIQUE INDEX requests_source_submissionid ON requests(source, submissionid)
+    WHERE submissionid <> '';
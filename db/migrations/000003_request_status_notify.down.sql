@@ -0,0 +1,2 @@
+DROP TRIGGER IF EXISTS requests_notify_status ON requests;
+DROP FUNCTION IF EXISTS notify_request_status ();
@@ -0,0 +1,24 @@
+-- publish request status changes so API servers and dispatchers sharing the
+-- database can follow them with LISTEN request_status
+CREATE OR REPLACE FUNCTION notify_request_status() RETURNS TRIGGER AS $delim$
+BEGIN
+    IF TG_OP = 'INSERT' OR NEW.status IS DISTINCT FROM OLD.status THEN
+        PERFORM pg_notify('request_status', json_build_object(
+            'uid', NEW.uid,
+            'source', NEW.source,
+            'destination', NEW.destination,
+            'batchid', NEW.batchid,
+            'status', NEW.status,
+            'previousStatus', CASE WHEN TG_OP = 'UPDATE' THEN OLD.status ELSE '' END,
+            'statuscode', COALESCE(NEW.statuscode, ''),
+            'errors', left(COALESCE(NEW.errors, ''), 1000), -- NOTIFY payloads are limited to 8000 bytes
+            'retries', NEW.retries,
+            'updated', NEW.updated)::text);
+    END IF;
+    RETURN NEW;
+END;
+$delim$ LANGUAGE plpgsql;
+
+CREATE TRIGGER requests_notify_status
+    AFTER INSERT OR UPDATE OF status ON requests
+    FOR EACH ROW EXECUTE PROCEDURE notify_request_status();
@@ -0,0 +1,5 @@
+ALTER TABLE servers
+    DROP COLUMN IF EXISTS url_params,
+    DROP COLUMN IF EXISTS endpoint_type,
+    DROP COLUMN IF EXISTS system_type,
+    DROP COLUMN IF EXISTS is_proxy_server;
@@ -0,0 +1,6 @@
+-- columns the servers API exposes that were missing from the initial schema
+ALTER TABLE servers
+    ADD COLUMN IF NOT EXISTS is_proxy_server BOOLEAN NOT NULL DEFAULT 'f', -- whether response is received as is
+    ADD COLUMN IF NOT EXISTS system_type TEXT NOT NULL DEFAULT '', -- the type of system e.g DHIS2
+    ADD COLUMN IF NOT EXISTS endpoint_type TEXT NOT NULL DEFAULT '', -- e.g /dataValueSets
+    ADD COLUMN IF NOT EXISTS url_params JSONB NOT NULL DEFAULT '{}'::JSONB; -- extra query parameters for url
@@ -0,0 +1,8 @@
+ALTER TABLE servers
+    DROP COLUMN IF EXISTS retry_jitter,
+    DROP COLUMN IF EXISTS retry_multiplier,
+    DROP COLUMN IF EXISTS retry_max_delay,
+    DROP COLUMN IF EXISTS retry_base_delay;
+
+DROP INDEX IF EXISTS requests_due_retries;
+ALTER TABLE requests DROP COLUMN IF EXISTS next_attempt_at;
@@ -0,0 +1,10 @@
+-- failed requests are retried once next_attempt_at is due
+ALTER TABLE requests ADD COLUMN IF NOT EXISTS next_attempt_at TIMESTAMPTZ;
+CREATE INDEX IF NOT EXISTS requests_due_retries ON requests(next_attempt_at) WHERE status = 'failed';
+
+-- exponential backoff settings of each destination
+ALTER TABLE servers
+    ADD COLUMN IF NOT EXISTS retry_base_delay INTEGER NOT NULL DEFAULT 30, -- seconds before the first retry
+    ADD COLUMN IF NOT EXISTS retry_max_delay INTEGER NOT NULL DEFAULT 3600, -- upper bound of the delay in seconds
+    ADD COLUMN IF NOT EXISTS retry_multiplier DOUBLE PRECISION NOT NULL DEFAULT 2, -- growth of the delay per retry
+    ADD COLUMN IF NOT EXISTS retry_jitter DOUBLE PRECISION NOT NULL DEFAULT 0.2; -- fraction of the delay randomized
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS requests_claimable;
+ALTER TABLE requests DROP COLUMN IF EXISTS lease_expires_at;
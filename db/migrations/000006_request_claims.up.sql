@@ -0,0 +1,3 @@
+-- requests are claimed by setting them inprogress with a lease; expired leases can be claimed again
+ALTER TABLE requests ADD COLUMN IF NOT EXISTS lease_expires_at TIMESTAMPTZ;
+CREATE INDEX IF NOT EXISTS requests_claimable ON requests(created) WHERE status IN ('ready', 'failed', 'inprogress');
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS requests_inprogress_destination;
+
+ALTER TABLE servers
+    DROP COLUMN IF EXISTS rate_burst,
+    DROP COLUMN IF EXISTS rate_period,
+    DROP COLUMN IF EXISTS rate_limit,
+    DROP COLUMN IF EXISTS max_in_flight;
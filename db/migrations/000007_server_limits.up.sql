@@ -0,0 +1,8 @@
+-- per destination limits enforced when claiming requests, 0 means unlimited
+ALTER TABLE servers
+    ADD COLUMN IF NOT EXISTS max_in_flight INTEGER NOT NULL DEFAULT 0, -- requests sent concurrently
+    ADD COLUMN IF NOT EXISTS rate_limit INTEGER NOT NULL DEFAULT 0, -- requests sent per rate_period
+    ADD COLUMN IF NOT EXISTS rate_period TEXT NOT NULL DEFAULT 'second' CHECK (rate_period IN ('second', 'minute')),
+    ADD COLUMN IF NOT EXISTS rate_burst INTEGER NOT NULL DEFAULT 0; -- requests that may be sent at once, 0 means 1
+
+CREATE INDEX IF NOT EXISTS requests_inprogress_destination ON requests(destination) WHERE status = 'inprogress';
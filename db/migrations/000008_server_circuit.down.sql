@@ -0,0 +1,8 @@
+ALTER TABLE servers
+    DROP COLUMN IF EXISTS circuit_probed_at,
+    DROP COLUMN IF EXISTS circuit_opened_at,
+    DROP COLUMN IF EXISTS circuit_probe_url,
+    DROP COLUMN IF EXISTS circuit_probe_interval,
+    DROP COLUMN IF EXISTS circuit_failure_threshold,
+    DROP COLUMN IF EXISTS circuit_failures,
+    DROP COLUMN IF EXISTS circuit_state;
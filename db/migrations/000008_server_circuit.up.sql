@@ -0,0 +1,9 @@
+-- circuit breaker of each destination, requests are not dispatched while the circuit is open
+ALTER TABLE servers
+    ADD COLUMN IF NOT EXISTS circuit_state TEXT NOT NULL DEFAULT 'closed' CHECK (circuit_state IN ('closed', 'open')),
+    ADD COLUMN IF NOT EXISTS circuit_failures INTEGER NOT NULL DEFAULT 0, -- consecutive connection failures or 5xx responses
+    ADD COLUMN IF NOT EXISTS circuit_failure_threshold INTEGER NOT NULL DEFAULT 5, -- failures that open the circuit, 0 disables it
+    ADD COLUMN IF NOT EXISTS circuit_probe_interval INTEGER NOT NULL DEFAULT 60, -- seconds between probes of an open circuit
+    ADD COLUMN IF NOT EXISTS circuit_probe_url TEXT NOT NULL DEFAULT '', -- defaults to /api/system/ping for DHIS2
+    ADD COLUMN IF NOT EXISTS circuit_opened_at TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS circuit_probed_at TIMESTAMPTZ;
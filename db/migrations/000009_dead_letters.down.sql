@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS requests_dead_letters;
+DROP VIEW IF EXISTS dead_letters;
+ALTER TABLE requests DROP COLUMN IF EXISTS response;
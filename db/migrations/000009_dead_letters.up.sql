@@ -0,0 +1,16 @@
+-- the last response received from the destination
+ALTER TABLE requests ADD COLUMN IF NOT EXISTS response TEXT NOT NULL DEFAULT '';
+
+-- requests that will not be sent again without an operator replaying them
+CREATE OR REPLACE VIEW dead_letters AS
+SELECT
+    r.id, r.uid, r.source, r.destination, COALESCE(s.name, '') AS destination_name, r.batchid,
+    r.status, COALESCE(r.statuscode, '') AS statuscode, COALESCE(r.errors, '') AS errors,
+    r.response, r.retries, r.object_type, r.ctype, r.created, r.updated
+FROM requests r
+LEFT JOIN servers s ON s.id = r.destination
+WHERE r.status IN ('expired', 'canceled', 'error')
+    OR (r.status = 'failed' AND r.next_attempt_at IS NULL);
+
+CREATE INDEX IF NOT EXISTS requests_dead_letters ON requests(destination, statuscode)
+    WHERE status IN ('expired', 'canceled', 'error', 'failed');
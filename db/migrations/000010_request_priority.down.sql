@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS report_type_priorities;
+ALTER TABLE servers DROP COLUMN IF EXISTS default_priority;
+DROP INDEX IF EXISTS requests_priority;
+ALTER TABLE requests DROP COLUMN IF EXISTS priority;
@@ -0,0 +1,15 @@
+-- requests with a higher priority are dispatched first, see PriorityAgingInterval for aging
+ALTER TABLE requests ADD COLUMN IF NOT EXISTS priority INTEGER NOT NULL DEFAULT 0;
+CREATE INDEX IF NOT EXISTS requests_priority ON requests(priority DESC, created)
+    WHERE status IN ('ready', 'failed', 'inprogress');
+
+-- priority of requests from a source that did not set one
+ALTER TABLE servers ADD COLUMN IF NOT EXISTS default_priority INTEGER NOT NULL DEFAULT 0;
+
+-- priority of requests of a report type that did not set one, takes precedence over the source
+CREATE TABLE IF NOT EXISTS report_type_priorities(
+    report_type TEXT PRIMARY KEY,
+    priority INTEGER NOT NULL DEFAULT 0,
+    created timestamptz DEFAULT current_timestamp,
+    updated timestamptz DEFAULT current_timestamp
+);
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS requests_async_jobs;
+ALTER TABLE requests
+    DROP COLUMN IF EXISTS job_id,
+    DROP COLUMN IF EXISTS job_type,
+    DROP COLUMN IF EXISTS job_location,
+    DROP COLUMN IF EXISTS job_started_at,
+    DROP COLUMN IF EXISTS job_checked_at;
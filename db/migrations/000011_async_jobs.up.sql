@@ -0,0 +1,8 @@
+-- DHIS2 import jobs started by requests to servers with use_async, the request stays pending until the job finishes
+ALTER TABLE requests
+    ADD COLUMN IF NOT EXISTS job_id TEXT,
+    ADD COLUMN IF NOT EXISTS job_type TEXT, -- e.g DATAVALUE_IMPORT, empty for tracker jobs
+    ADD COLUMN IF NOT EXISTS job_location TEXT,
+    ADD COLUMN IF NOT EXISTS job_started_at TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS job_checked_at TIMESTAMPTZ;
+CREATE INDEX IF NOT EXISTS requests_async_jobs ON requests(job_checked_at) WHERE status = 'pending' AND job_id IS NOT NULL;
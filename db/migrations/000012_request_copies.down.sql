@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS requests_parent_id;
+ALTER TABLE requests
+    DROP COLUMN IF EXISTS parent_id,
+    DROP COLUMN IF EXISTS cc_url;
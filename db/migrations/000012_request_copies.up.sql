@@ -0,0 +1,6 @@
+-- copies of a request delivered to the cc_urls of a destination with allow_copies, each copy is
+-- a request of its own linked to the request it copies
+ALTER TABLE requests
+    ADD COLUMN IF NOT EXISTS parent_id BIGINT REFERENCES requests(id) ON DELETE CASCADE,
+    ADD COLUMN IF NOT EXISTS cc_url TEXT NOT NULL DEFAULT ''; -- URL a copy is sent to instead of the destination URL
+CREATE INDEX IF NOT EXISTS requests_parent_id ON requests(parent_id) WHERE parent_id IS NOT NULL;
@@ -0,0 +1,4 @@
+DROP TRIGGER IF EXISTS requests_queue_callback ON requests;
+DROP FUNCTION IF EXISTS queue_request_callback();
+DROP TABLE IF EXISTS callback_attempts;
+DROP TABLE IF EXISTS callback_deliveries;
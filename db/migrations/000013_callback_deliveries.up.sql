@@ -0,0 +1,48 @@
+-- results posted to the callback_url of the source of a request once the request reaches a final status
+CREATE TABLE IF NOT EXISTS callback_deliveries(
+    id BIGSERIAL PRIMARY KEY,
+    request_id BIGINT NOT NULL REFERENCES requests(id) ON DELETE CASCADE,
+    server_id INTEGER NOT NULL REFERENCES servers(id) ON DELETE CASCADE, -- the source receiving the callback
+    url TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'delivered', 'failed')),
+    request_status TEXT NOT NULL, -- final status of the request when the callback was queued
+    statuscode TEXT NOT NULL DEFAULT '',
+    errors TEXT NOT NULL DEFAULT '',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ DEFAULT current_timestamp,
+    delivered_at TIMESTAMPTZ,
+    created timestamptz DEFAULT current_timestamp,
+    updated timestamptz DEFAULT current_timestamp
+);
+CREATE INDEX IF NOT EXISTS callback_deliveries_due ON callback_deliveries(next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS callback_deliveries_request ON callback_deliveries(request_id);
+
+-- every attempt to deliver a callback
+CREATE TABLE IF NOT EXISTS callback_attempts(
+    id BIGSERIAL PRIMARY KEY,
+    delivery_id BIGINT NOT NULL REFERENCES callback_deliveries(id) ON DELETE CASCADE,
+    response_code INTEGER, -- NULL when the callback_url could not be reached
+    response TEXT NOT NULL DEFAULT '',
+    error TEXT NOT NULL DEFAULT '',
+    created timestamptz DEFAULT current_timestamp
+);
+CREATE INDEX IF NOT EXISTS callback_attempts_delivery ON callback_attempts(delivery_id);
+
+-- queue a callback when a request, other than a copy, reaches a final status and its source allows callbacks
+CREATE OR REPLACE FUNCTION queue_request_callback() RETURNS TRIGGER AS $delim$
+BEGIN
+    IF NEW.parent_id IS NULL AND NEW.status IS DISTINCT FROM OLD.status
+        AND (NEW.status IN ('completed', 'expired', 'error', 'canceled')
+            OR (NEW.status = 'failed' AND NEW.next_attempt_at IS NULL)) THEN
+        INSERT INTO callback_deliveries (request_id, server_id, url, request_status, statuscode, errors)
+        SELECT NEW.id, s.id, s.callback_url, NEW.status, COALESCE(NEW.statuscode, ''), COALESCE(NEW.errors, '')
+        FROM servers s
+        WHERE s.id = NEW.source AND s.allow_callbacks AND COALESCE(s.callback_url, '') <> '';
+    END IF;
+    RETURN NEW;
+END;
+$delim$ LANGUAGE plpgsql;
+
+CREATE TRIGGER requests_queue_callback
+    AFTER UPDATE OF status ON requests
+    FOR EACH ROW EXECUTE PROCEDURE queue_request_callback();
@@ -0,0 +1,3 @@
+ALTER TABLE servers
+    DROP COLUMN IF EXISTS ssl_ca_bundle_file,
+    DROP COLUMN IF EXISTS ssl_insecure;
@@ -0,0 +1,4 @@
+-- TLS settings of the connections to a server, certificates are verified unless ssl_insecure is set
+ALTER TABLE servers
+    ADD COLUMN IF NOT EXISTS ssl_ca_bundle_file TEXT NOT NULL DEFAULT '', -- PEM CAs trusted besides the system roots
+    ADD COLUMN IF NOT EXISTS ssl_insecure BOOLEAN NOT NULL DEFAULT FALSE; -- skip certificate verification, for testing only
@@ -0,0 +1,3 @@
+-- settings of the auth_method of a server, e.g. the token URL of OAuth2 or the header of an API key.
+-- Secrets stay in password and auth_token
+ALTER TABLE servers ADD COLUMN IF NOT EXISTS auth_params JSONB NOT NULL DEFAULT '{}'::jsonb;
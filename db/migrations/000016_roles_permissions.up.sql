@@ -0,0 +1,5 @@
+-- managing roles needs permissions on the Roles module, roles that could manage users keep
+-- managing roles as well
+INSERT INTO user_role_permissions (user_role, sys_module, sys_perms)
+SELECT user_role, 'Roles', sys_perms FROM user_role_permissions WHERE sys_module = 'Users'
+ON CONFLICT (sys_module, user_role) DO NOTHING;
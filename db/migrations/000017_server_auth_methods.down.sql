@@ -0,0 +1 @@
+-- the original spellings of auth_method are not kept, they all meant Basic auth
@@ -0,0 +1,3 @@
+-- the dispatcher used to send Basic auth for every auth_method other than Token, keep doing
+-- so for servers configured with other spellings such as "Basic Authentication"
+UPDATE servers SET auth_method = 'Basic Auth' WHERE auth_method NOT IN ('', 'Basic Auth', 'Token');
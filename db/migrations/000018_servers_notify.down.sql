@@ -0,0 +1,2 @@
+DROP TRIGGER IF EXISTS servers_notify_changed ON servers;
+DROP FUNCTION IF EXISTS notify_servers_changed();
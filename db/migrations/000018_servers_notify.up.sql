@@ -0,0 +1,18 @@
+-- tell the dispatchers and API servers sharing the database to reload their servers
+-- after a configuration change. The circuit breaker updates its columns on its own
+-- and the dispatchers read them from the database, so those updates are skipped
+CREATE OR REPLACE FUNCTION notify_servers_changed() RETURNS TRIGGER AS $delim$
+BEGIN
+    IF TG_OP <> 'UPDATE' OR to_jsonb(NEW) - ARRAY['circuit_state', 'circuit_failures',
+            'circuit_opened_at', 'circuit_probed_at'] IS DISTINCT FROM
+        to_jsonb(OLD) - ARRAY['circuit_state', 'circuit_failures',
+            'circuit_opened_at', 'circuit_probed_at'] THEN
+        PERFORM pg_notify('servers_changed', '');
+    END IF;
+    RETURN NULL;
+END;
+$delim$ LANGUAGE plpgsql;
+
+CREATE TRIGGER servers_notify_changed
+    AFTER INSERT OR UPDATE OR DELETE ON servers
+    FOR EACH ROW EXECUTE PROCEDURE notify_servers_changed();
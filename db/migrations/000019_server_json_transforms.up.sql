@@ -0,0 +1,2 @@
+-- transformations of the JSON bodies sent to a server keyed by object type, see JSONTransform
+ALTER TABLE servers ADD COLUMN IF NOT EXISTS json_transforms JSONB NOT NULL DEFAULT '{}'::jsonb;
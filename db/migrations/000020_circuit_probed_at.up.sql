@@ -0,0 +1,3 @@
+-- the failures below the threshold and the probes of open circuits are tracked by each
+-- dispatcher in memory, only the opening and closing of a circuit is stored
+ALTER TABLE servers DROP COLUMN IF EXISTS circuit_probed_at;
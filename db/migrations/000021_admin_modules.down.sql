@@ -0,0 +1 @@
+DELETE FROM user_role_permissions WHERE sys_module IN ('Servers', 'Queue');
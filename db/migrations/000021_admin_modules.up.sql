@@ -0,0 +1,6 @@
+-- managing servers needs permissions on the Servers module, and managing dead letters,
+-- priorities and callbacks permissions on the Queue module
+INSERT INTO user_role_permissions (user_role, sys_module, sys_perms)
+SELECT id, m.sys_module, 'rmad' FROM user_roles, (VALUES ('Servers'), ('Queue')) AS m(sys_module)
+WHERE name = 'Administrator'
+ON CONFLICT (sys_module, user_role) DO NOTHING;
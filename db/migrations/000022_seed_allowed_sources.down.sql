@@ -0,0 +1,2 @@
+-- the allowed sources added from the existing requests are kept, they can no longer be told
+-- apart from those configured through the API
@@ -0,0 +1,13 @@
+-- destinations accept requests only from their allowed sources, and the initial data set them
+-- only for dhis2. Allow every source that already sent requests to a destination so that
+-- existing routes keep working
+INSERT INTO server_allowed_sources (server_id, allowed_sources)
+SELECT destination, array_agg(DISTINCT source ORDER BY source)
+FROM requests
+WHERE source IS NOT NULL AND destination IS NOT NULL AND source <> destination
+GROUP BY destination
+ON CONFLICT (server_id) DO UPDATE
+SET allowed_sources = ARRAY(
+        SELECT DISTINCT unnest(server_allowed_sources.allowed_sources || EXCLUDED.allowed_sources)
+        ORDER BY 1),
+    updated = now();
@@ -2,19 +2,19 @@ package main
 
 import (
 	"bytes"
-	"crypto/tls"
-	"encoding/base64"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/app"
 	"fyne.io/fyne/v2/container"
 	"fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
-	"github.com/buger/jsonparser"
 	"github.com/gcinnovate/integrator/controllers"
 	"github.com/gcinnovate/integrator/models"
 	"github.com/gcinnovate/integrator/pages"
+	"github.com/gcinnovate/integrator/utils/httpclient"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-migrate/migrate/v4"
 	_ "github.com/golang-migrate/migrate/v4/database/postgres"
@@ -26,7 +26,6 @@ import (
 	"net/http"
 	"net/url"
 	"os"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -51,6 +50,12 @@ func init() {
 		UseGlobalSubmissionPeriod: "true",
 		UseSSL:                    "false",
 		RequestProcessInterval:    4,
+		RequestLeaseTime:          300,
+		PriorityAgingInterval:     300,
+		AsyncJobPollInterval:      15,
+		AsyncJobTimeout:           3600,
+		CallbackMaxAttempts:       10,
+		ShutdownTimeout:           30,
 	}
 }
 
@@ -65,20 +70,29 @@ type RequestObj struct {
 	ContentType        string               `db:"ctype"`
 	ObjectType         string               `db:"object_type"`
 	BodyIsQueryParams  bool                 `db:"body_is_query_param"`
-	SubmissionID       int64                `db:"submissionid"`
+	SubmissionID       string               `db:"submissionid"`
 	URLSurffix         string               `db:"url_suffix"`
 	Suspended          bool                 `db:"suspended"`
 	Status             models.RequestStatus `db:"status"`
 	StatusCode         string               `db:"statuscode"`
 	Errors             string               `db:"errors"`
+	NextAttemptAt      *time.Time           `db:"next_attempt_at"`
+	Response           string               `db:"response"`
+	ParentID           *models.RequestID    `db:"parent_id"` // set for copies to the cc_urls of the destination
+	CCURL              string               `db:"cc_url"`
 }
 
+// isCopy returns whether the request is a copy sent to a cc_url of its destination. Copies
+// are retried on their own and do not count towards the circuit breaker of the destination
+func (r *RequestObj) isCopy() bool { return r.ParentID != nil }
+
 const updateRequestSQL = `
-UPDATE requests SET (status, statuscode, errors, retries, updated)
-	= (:status, :statuscode, :errors, :retries, timeofday()::::timestamp) WHERE id = :id
+UPDATE requests SET (status, statuscode, errors, response, retries, next_attempt_at, lease_expires_at, updated)
+	= (:status, :statuscode, :errors, :response, :retries, :next_attempt_at, NULL, timeofday()::::timestamp)
+	WHERE id = :id
 `
 const updateStatusSQL = `
-	UPDATE requests SET (status,  updated) = (:status, timeofday()::::timestamp)
+	UPDATE requests SET (status, lease_expires_at, updated) = (:status, NULL, timeofday()::::timestamp)
 	WHERE id = :id`
 
 // updateRequest is used by consumers to update request in the db
@@ -98,6 +112,134 @@ func (r *RequestObj) updateRequestStatus(tx *sqlx.Tx) {
 }
 func (r *RequestObj) withStatus(s models.RequestStatus) *RequestObj { r.Status = s; return r }
 
+// scheduleRetry records a failed attempt to send the request. The request is picked up again
+// once the backoff of the destination has passed, and expires after MaxRetries retries
+func (r *RequestObj) scheduleRetry(tx *sqlx.Tx, server models.Server, statusCode, errors string) {
+	r.scheduleRetryWith(tx, server.RetryPolicy(), statusCode, errors)
+}
+
+// scheduleRetryWith records a failed attempt to send the request, backing off with policy
+func (r *RequestObj) scheduleRetryWith(tx *sqlx.Tx, policy models.RetryPolicy, statusCode, errors string) {
+	r.StatusCode = statusCode
+	r.Errors = errors
+	r.Retries += 1
+	if r.Retries > Dispatcher2Conf.MaxRetries {
+		r.Status = models.RequestStatusExpired
+		r.NextAttemptAt = nil
+	} else {
+		r.Status = models.RequestStatusFailed
+		next := time.Now().Add(policy.Delay(r.Retries))
+		r.NextAttemptAt = &next
+	}
+	r.updateRequest(tx)
+	log.WithFields(log.Fields{
+		"request":     r.ID,
+		"retries":     r.Retries,
+		"status":      r.Status,
+		"nextAttempt": r.NextAttemptAt}).Info("Scheduled retry of failed request")
+}
+
+// maxResponseSize is the most of a response body that is read and kept with a request
+const maxResponseSize = 1 << 20
+
+// serverFailureStatus returns whether an HTTP response status means the destination itself is failing
+func serverFailureStatus(code int) bool {
+	return code >= 500 || code == http.StatusRequestTimeout
+}
+
+// failAttempt handles a connection failure or 5xx response of the destination. The failure
+// counts towards the circuit breaker of the destination, and once its circuit is open the
+// request is held without using up a retry until the destination is back
+func (r *RequestObj) failAttempt(db *sqlx.DB, tx *sqlx.Tx, server models.Server, statusCode, errors string) {
+	if r.isCopy() {
+		r.scheduleRetry(tx, server, statusCode, errors)
+		return
+	}
+	open, err := models.RecordServerFailure(db, server)
+	if err != nil {
+		log.WithError(err).WithField("server", server.ID()).Error("Failed to record server failure")
+	}
+	if !open {
+		r.scheduleRetry(tx, server, statusCode, errors)
+		return
+	}
+	r.Status = models.RequestStatusReady
+	r.StatusCode = statusCode
+	r.Errors = errors
+	r.NextAttemptAt = nil
+	r.updateRequest(tx)
+	log.WithFields(log.Fields{
+		"request": r.ID,
+		"server":  server.ID()}).Info("Holding request until the circuit of the server closes")
+}
+
+// completeRequest sets the outcome of a request the destination accepted from the import
+// summary in its response. Responses without an import summary complete the request
+func (r *RequestObj) completeRequest(tx *sqlx.Tx, httpStatus string, body []byte) {
+	outcome, ok := models.InterpretImportSummary(body)
+	if !ok {
+		outcome = models.ImportOutcome{Status: models.RequestStatusCompleted, StatusCode: httpStatus}
+	}
+	r.Status = outcome.Status
+	r.StatusCode = outcome.StatusCode
+	r.Errors = outcome.Errors
+	r.NextAttemptAt = nil
+	r.updateRequest(tx)
+	log.WithFields(log.Fields{
+		"request":      r.ID,
+		"status":       outcome.Status,
+		"importStatus": outcome.StatusCode,
+		"importCount":  outcome.Count,
+		"errors":       outcome.Errors,
+	}).Info("Request processed")
+}
+
+// failResponse handles a response of the destination that is not successful. Server failures
+// count towards the circuit breaker, rate limited requests are retried after a backoff and
+// any other response fails the request, with the status and conflicts of the import summary
+// when the response has one
+func (r *RequestObj) failResponse(db *sqlx.DB, tx *sqlx.Tx, server models.Server, resp *http.Response, body []byte) {
+	result := models.ImportSummary{}
+	_ = json.Unmarshal(body, &result)
+	switch {
+	case serverFailureStatus(resp.StatusCode):
+		r.failAttempt(db, tx, server, resp.Status, result.Message)
+	case resp.StatusCode == http.StatusTooManyRequests:
+		r.scheduleRetry(tx, server, resp.Status, result.Message)
+	default:
+		r.Status = models.RequestStatusFailed
+		r.StatusCode = resp.Status
+		r.Errors = result.Message
+		if outcome, ok := models.InterpretImportSummary(body); ok && outcome.Status == models.RequestStatusFailed {
+			r.StatusCode = outcome.StatusCode
+			r.Errors = outcome.Errors
+		}
+		r.NextAttemptAt = nil
+		r.updateRequest(tx)
+	}
+}
+
+// startAsyncJob leaves the request pending on the import job referenced by the response to an
+// async import. pollAsyncJobs follows the job and sets the final status of the request
+func (r *RequestObj) startAsyncJob(tx *sqlx.Tx, statusCode string, body []byte) {
+	job, err := models.ParseAsyncJob(body)
+	if err != nil {
+		r.Status = models.RequestStatusFailed
+		r.StatusCode = statusCode
+		r.Errors = err.Error()
+		r.updateRequest(tx)
+		return
+	}
+	if err := models.StartAsyncJob(tx, r.ID, job, r.Response); err != nil {
+		log.WithError(err).WithField("request", r.ID).Error("Failed to record import job")
+		return
+	}
+	log.WithFields(log.Fields{
+		"request": r.ID,
+		"job":     job.ID,
+		"jobType": job.Type}).Info("Request is pending on import job")
+}
+
 func (r *RequestObj) canSendRequest(tx *sqlx.Tx, server models.Server) bool {
 	// check if we have exceeded retries
 	if r.Retries > Dispatcher2Conf.MaxRetries {
@@ -147,213 +289,251 @@ func (r *RequestObj) canSendRequest(tx *sqlx.Tx, server models.Server) bool {
 	return true
 }
 
-func (r *RequestObj) unMarshalBody() (interface{}, error) {
-	var data interface{}
-	switch r.ObjectType {
-	case "DATA_VALUES":
-		data = models.DataValuesRequest{}
-		err := json.Unmarshal([]byte(r.Body), &data)
+// releaseAborted returns a request whose send was aborted by shutdown to the queue, the aborted
+// send does not count as an attempt against the destination
+func (r *RequestObj) releaseAborted(tx *sqlx.Tx) {
+	log.WithField("request", r.ID).Warn("Sending aborted by shutdown, releasing request")
+	if err := models.ReleaseRequest(tx, r.ID); err != nil {
+		log.WithError(err).WithField("request", r.ID).Error("Failed to release request")
+	}
+	if err := tx.Commit(); err != nil {
+		log.WithError(err).WithField("request", r.ID).Error("Failed to commit released request")
+	}
+}
+
+// requestURL returns the URL the request is sent to: the destination URL, or the cc_url of a
+// copy, with the url_suffix of the request appended
+func (r *RequestObj) requestURL(destination models.Server) (string, error) {
+	target := destination.URL()
+	if r.CCURL != "" {
+		target = r.CCURL
+	}
+	return models.AppendURLSuffix(target, r.URLSurffix)
+}
+
+// sendRequest sends request to destination server. Bodies flagged as query params are
+// encoded into the query string and the request is sent without a body, other bodies are
+// encoded for their content type. Copies are sent to their cc_url without the credentials or
+// client certificate of the destination. Canceling ctx aborts the request
+func (r *RequestObj) sendRequest(ctx context.Context, destination models.Server) (*http.Response, error) {
+	target, err := r.requestURL(destination)
+	if err != nil {
+		return nil, err
+	}
+	var req *http.Request
+	if r.BodyIsQueryParams {
+		params, err := models.BodyQueryParams(r.Body, r.ContentType)
 		if err != nil {
 			return nil, err
 		}
-	case "BULK_DATA_VALUES":
-		data = models.BulkDataValuesRequest{}
-		err := json.Unmarshal([]byte(r.Body), &data)
-		if err != nil {
+		if target, err = models.WithQueryParams(target, params); err != nil {
 			return nil, err
 		}
-	case "TRACKED_ENTITIES":
-		data = pages.TeisPayload{}
-		err := json.Unmarshal([]byte(r.Body), &data)
-		if err != nil {
+		if req, err = http.NewRequestWithContext(ctx, destination.HTTPMethod(), target, nil); err != nil {
 			return nil, err
 		}
-	case "EVENTS":
-		data = pages.EventsPayload{}
-		err := json.Unmarshal([]byte(r.Body), &data)
-		if err != nil {
-			return nil, err
-		}
-	case "ENROLLMENTS":
-		data = pages.EnrollmentsPayload{}
-		err := json.Unmarshal([]byte(r.Body), &data)
+		req.Header.Set("Accept", "application/json")
+	} else {
+		body, err := destination.EncodeBody(r.Body, r.ContentType, r.ObjectType)
 		if err != nil {
 			return nil, err
 		}
-	default:
-		data = map[string]interface{}{}
-		err := json.Unmarshal([]byte(r.Body), &data)
+		req, err = http.NewRequestWithContext(ctx, destination.HTTPMethod(), target, bytes.NewReader(body.Body))
 		if err != nil {
 			return nil, err
 		}
-
+		req.Header.Set("Content-Type", body.ContentType)
+		req.Header.Set("Accept", body.Accept)
 	}
-	return data, nil
-}
-
-// sendRequest sends request to destination server
-func (r *RequestObj) sendRequest(destination models.Server) (*http.Response, error) {
-	data, err := r.unMarshalBody()
-	if err != nil {
+	if r.isCopy() {
+		// a cc_url is another host, it must not see the credentials of the destination
+		return httpclient.Default().Do(req)
+	}
+	if err := destination.Authenticate(req); err != nil {
 		return nil, err
 	}
-	marshalled, err := json.Marshal(data)
+
+	client, err := destination.HTTPClient()
 	if err != nil {
-		fmt.Printf("Failed to marshal request body")
 		return nil, err
 	}
-	req, err := http.NewRequest(destination.HTTPMethod(), destination.URL(), bytes.NewReader(marshalled))
-
-	switch destination.AuthMethod() {
-	case "Token":
-		// Add API token
-		tokenAuth := "ApiToken " + destination.AuthToken()
-		req.Header.Set("Authorization", tokenAuth)
-		log.WithField("AuthToken", tokenAuth).Info("The authentication token:")
-	default: // Basic Auth
-		// Add basic authentication
-		auth := destination.Username() + ":" + destination.Password()
-		basicAuth := "Basic " + base64.StdEncoding.EncodeToString([]byte(auth))
-		req.Header.Set("Authorization", basicAuth)
-
-	}
-
-	req.Header.Set("Content-Type", r.ContentType)
-	// Create custom transport with TLS settings
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{
-			// Set any necessary TLS settings here
-			// For example, to disable certificate validation:
-			InsecureSkipVerify: true,
-		},
-	}
-
-	client := &http.Client{Transport: tr}
-
 	resp, err := client.Do(req)
 	if err != nil {
 		return nil, err
 	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		destination.InvalidateAuth()
+	}
 	return resp, nil
 }
 
+// produce claims batches of requests and hands them to the consumers. It waits for a request
+// to be queued before claiming again, polling every RequestProcessInterval for due retries and
+// expired leases. Each destination is claimed within its own in-flight and rate limits so that
+// a slow destination cannot occupy every consumer, and higher priority requests are claimed first.
+// Once the dispatcher is stopping it releases the requests it could not hand over and closes jobs
 func produce(db *sqlx.DB, jobs chan<- int, wg *sync.WaitGroup) {
 	defer wg.Done()
+	defer close(jobs)
 	log.Println("Producer staring:!!!")
-	for {
-		log.Println("Going to read requests")
-		rows, err := db.Queryx(`
-                SELECT id FROM requests WHERE status = $1 ORDER BY created LIMIT 100000
-                `, "ready")
+	lease := time.Duration(Dispatcher2Conf.RequestLeaseTime) * time.Second
+	interval := time.Duration(Dispatcher2Conf.RequestProcessInterval) * time.Second
+	aging := time.Duration(Dispatcher2Conf.PriorityAgingInterval) * time.Second
+	batchSize := Dispatcher2Conf.MaxConcurrent
+	ready := models.RequestsReady()
+	limiter := models.NewRateLimiter()
+	for stopping.Err() == nil {
+		budgets, refill := limiter.Budgets(models.Servers())
+		claimed, err := models.ClaimRequests(db, batchSize, lease, budgets, aging)
 		if err != nil {
-			log.Fatalln(err)
+			log.WithError(err).Error("Failed to claim requests")
 		}
-
-		for rows.Next() {
-			var requestID int
-			err := rows.Scan(&requestID)
-			if err != nil {
-				log.Fatalln("==>", err)
+		for i, req := range claimed {
+			select {
+			case jobs <- int(req.ID):
+				if !req.IsCopy {
+					limiter.Take(req.Destination)
+				}
+			case <-stopping.Done():
+				releaseClaimed(db, claimed[i:])
+				return
 			}
-			// log.Printf("Adding request [id: %v]\n", requestID)
-
-			go func() {
-				jobs <- requestID
-			}()
-			log.Printf("Added Request [id: %v]\n", requestID)
 		}
-		if err := rows.Err(); err != nil {
-			log.Println(err)
+		if len(claimed) > 0 {
+			log.WithField("requests", len(claimed)).Info("Claimed requests")
+		}
+		if len(claimed) == batchSize {
+			continue // there are probably more requests waiting
+		}
+		wait := interval
+		if refill > 0 && refill < wait {
+			wait = refill // a rate limited destination may have requests waiting
 		}
-		rows.Close()
+		select {
+		case <-ready:
+		case <-time.After(wait):
+		case <-stopping.Done():
+			return
+		}
+	}
+}
 
-		log.Println("Fetch Requests")
-		log.Printf("Going to sleep for: %v", Dispatcher2Conf.RequestProcessInterval)
-		// Not good enough but let's bare with the sleep this initial version
-		time.Sleep(
-			time.Duration(Dispatcher2Conf.RequestProcessInterval) * time.Second)
+// releaseClaimed returns claimed requests that were not handed to a consumer to the queue
+func releaseClaimed(db *sqlx.DB, claimed []models.ClaimedRequest) {
+	for _, req := range claimed {
+		if err := models.ReleaseRequest(db, req.ID); err != nil {
+			log.WithError(err).WithField("request", req.ID).Error("Failed to release request")
+		}
 	}
+	log.WithField("requests", len(claimed)).Info("Released claimed requests")
 }
 
-// consume is the consumer go routine
+// consume is the consumer go routine. It handles requests until the producer closes jobs, every
+// request is committed before the next one is taken so that stopping never leaves a transaction open
 func consume(db *sqlx.DB, worker int, jobs <-chan int, wg *sync.WaitGroup) {
 	defer wg.Done()
+	defer db.Close()
 	fmt.Println("Calling Consumer")
 
 	for req := range jobs {
 		fmt.Printf("Message %v is consumed by worker %v.\n", req, worker)
 
 		reqObj := RequestObj{}
-		tx := db.MustBegin()
-		err := tx.QueryRowx(`
+		tx, err := db.Beginx()
+		if err != nil {
+			// the request stays claimed and is recovered when its lease expires
+			log.WithError(err).WithField("request", req).Error("Failed to start request transaction")
+			continue
+		}
+		err = tx.QueryRowx(`
                 SELECT
                         id, source, destination, body, retries, in_submission_period(destination),
                         ctype, object_type, body_is_query_param, submissionid, url_suffix,suspended,
-                        statuscode, status, errors
+                        statuscode, status, errors, parent_id, cc_url
                         
                 FROM requests
-                WHERE id = $1 FOR UPDATE NOWAIT`, req).StructScan(&reqObj)
+                WHERE id = $1 AND status = 'inprogress' FOR UPDATE SKIP LOCKED`, req).StructScan(&reqObj)
 		if err != nil {
 			log.WithError(err).Error("Error reading request for processing")
+			_ = tx.Rollback()
+			continue
 		}
 		log.WithFields(log.Fields{
 			"worker":     worker,
 			"request-ID": req}).Info("Handling Request")
 		/* Work on the request */
 		// dest = utils.GetServer(reqObj.Destination)
-		log.WithFields(log.Fields{"servers": models.ServerMap}).Info("Servers")
-		if server, ok := models.ServerMap[strconv.Itoa(reqObj.Destination)]; ok {
-			fmt.Printf("Found Server Config: %v, URL: %s\n", server, server.URL())
+		server, ok := models.LookupServer(reqObj.Destination)
+		if !ok {
+			// the destination may have been added since the servers were last loaded
+			if err := models.LoadServers(); err != nil {
+				log.WithError(err).Error("Failed to reload servers")
+			}
+			server, ok = models.LookupServer(reqObj.Destination)
+		}
+		if ok {
+			fmt.Printf("Found Server Config: %s, URL: %s\n", server.Name(), server.URL())
 			if reqObj.canSendRequest(tx, server) {
 				log.WithFields(log.Fields{"request": reqObj.ID}).Info("Request can be processed")
 				// send request
-				resp, err := reqObj.sendRequest(server)
+				resp, err := reqObj.sendRequest(aborting, server)
+				if err != nil && aborting.Err() != nil {
+					reqObj.releaseAborted(tx)
+					continue
+				}
+				if errors.Is(err, models.ErrAuthentication) {
+					// a problem with the credentials or token endpoint, not with the destination
+					log.WithError(err).WithField("RequestID", reqObj.ID).Error("Failed to authenticate request")
+					reqObj.scheduleRetry(tx, server, "ERROR06", err.Error())
+					tx.Commit()
+					continue
+				}
 				if err != nil {
 					log.WithError(err).WithField("RequestID", reqObj.ID).Error(
 						"Failed to send request")
-					reqObj.Status = models.RequestStatusFailed
-					reqObj.StatusCode = "ERROR02"
-					reqObj.Errors = "Server possibly unreachable"
-					reqObj.Retries += 1
-					reqObj.updateRequest(tx)
-					return
+					reqObj.failAttempt(db, tx, server, "ERROR02", "Server possibly unreachable")
+					tx.Commit()
+					continue
 				}
 
-				if !server.UseAsync() {
-					result := models.ImportSummary{}
-					json.NewDecoder(resp.Body).Decode(&result)
-					if resp.StatusCode/100 == 2 {
-						reqObj.withStatus(models.RequestStatusCompleted).updateRequestStatus(tx)
-						log.WithFields(log.Fields{
-							"status":      result.Response.Status,
-							"description": result.Response.Description,
-							"importCount": result.Response.ImportCount,
-							"conflicts":   result.Response.Conflicts,
-						}).Info("Request completed successfully!")
-						// fmt.Printf("Request Completed Successfully: %v\n", result)
-					}
-				} else {
-					// var result map[string]interface{}
-					// json.NewDecoder(resp.Body).Decode(&result)
-					bodyBytes, err := io.ReadAll(resp.Body)
-					if err != nil {
-						reqObj.withStatus(models.RequestStatusFailed).updateRequestStatus(tx)
-						log.WithError(err).Error("Could not read response")
-						return
-					}
-					log.WithField("responseBytes", bodyBytes).Info("Response Payload")
-					if resp.StatusCode/100 == 2 {
-						v, _, _, _ := jsonparser.Get(bodyBytes, "status")
-						fmt.Println(v)
-					}
-
+				bodyBytes, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+				if err != nil && aborting.Err() != nil {
+					resp.Body.Close()
+					reqObj.releaseAborted(tx)
+					continue
+				}
+				if err != nil {
+					log.WithError(err).Error("Could not read response")
+					reqObj.scheduleRetry(tx, server, "ERROR03", "Could not read response")
+					resp.Body.Close()
+					tx.Commit()
+					continue
+				}
+				reqObj.Response = string(bodyBytes)
+				if !serverFailureStatus(resp.StatusCode) && !reqObj.isCopy() {
+					models.RecordServerSuccess(server.ID())
+				}
+				switch {
+				case resp.StatusCode/100 != 2:
+					reqObj.failResponse(db, tx, server, resp, bodyBytes)
+				case server.UseAsync() && !reqObj.isCopy():
+					reqObj.startAsyncJob(tx, resp.Status, bodyBytes)
+				default:
+					reqObj.completeRequest(tx, resp.Status, bodyBytes)
 				}
 				resp.Body.Close()
+			} else if reqObj.Status == models.RequestStatusInProgress {
+				// not sendable right now, e.g. the destination was suspended after the claim
+				if err := models.ReleaseRequest(tx, reqObj.ID); err != nil {
+					log.WithError(err).WithField("request", reqObj.ID).Error("Failed to release request")
+				}
 			}
 
 		} else {
 			log.WithFields(log.Fields{"server": reqObj.Destination}).Info(
 				"Failed to load server configuration")
+			// retried with backoff so that the request is not claimed again straight away
+			reqObj.scheduleRetryWith(tx, models.DefaultRetryPolicy, "ERROR07", "Destination server not found")
 		}
 
 		tx.Commit()
@@ -382,10 +562,16 @@ func main() {
 	if err := m.Up(); err != nil && err != migrate.ErrNoChange {
 		log.Fatal("Error running migration:", err)
 	}
+	// servers are loaded after the migrations so that they have every column
+	if err := models.LoadServers(); err != nil {
+		log.Fatal("Failed to load servers:", err)
+	}
 	dbConn, err := sqlx.Connect("postgres", Dispatcher2Conf.Dispatcher2Db)
 	if err != nil {
 		log.Fatalln(err)
 	}
+	recoverRequests(dbConn)
+	pages.AuthenticateRequest = models.AuthenticateTrackerRequest
 	jobs := make(chan int)
 	var wg sync.WaitGroup
 
@@ -397,7 +583,16 @@ func main() {
 	wg.Add(1)
 	go startConsumers(jobs, &wg, a)
 
-	go startAPIServer()
+	wg.Add(4)
+	go func() {
+		defer wg.Done()
+		models.ListenForEvents(stopping, Dispatcher2Conf.Dispatcher2Db)
+	}()
+	go probeCircuits(dbConn, &wg)
+	go pollAsyncJobs(dbConn, &wg)
+	go deliverCallbacks(dbConn, &wg)
+	apiServer := startAPIServer()
+	go shutdownOnSignal(apiServer, &wg, a.Quit)
 	w := a.NewWindow("Integrator")
 	topWindow = w
 
@@ -443,12 +638,9 @@ func main() {
 	w.Resize(fyne.NewSize(1200, 700))
 	w.CenterOnScreen()
 
-	go func() {
-		wg.Wait()
-		a.Quit()
-	}()
 	w.ShowAndRun()
-	// Wait for all goroutines to finish
+	// closing the window or a signal ends the app, let the workers finish before exiting
+	shutdown(apiServer, &wg)
 }
 
 func logLifecycle(a fyne.App) {
@@ -457,7 +649,6 @@ func logLifecycle(a fyne.App) {
 	})
 	a.Lifecycle().SetOnStopped(func() {
 		log.Println("Lifecycle: Stopped")
-		os.Exit(1)
 	})
 	a.Lifecycle().SetOnEnteredForeground(func() {
 		log.Println("Lifecycle: Entered Foreground")
@@ -577,8 +768,9 @@ func shortcutFocused(s fyne.Shortcut, w fyne.Window) {
 	}
 }
 
-func startAPIServer() {
-	// defer wg.Done()
+// startAPIServer starts serving the API in the background and returns the server so that it
+// can be shut down
+func startAPIServer() *http.Server {
 	router := gin.Default()
 	// done := make(chan bool)
 	v2 := router.Group("/api", BasicAuth())
@@ -589,9 +781,89 @@ func startAPIServer() {
 
 		q := new(controllers.QueueController)
 		v2.POST("/queue", q.Queue)
+		v2.POST("/queue/bulk", q.BulkQueue)
+		v2.POST("/queue/validate", q.ValidateQueue)
+		v2.GET("/queue/events", q.StatusEvents)
 		v2.GET("/queue", q.Requests)
 		v2.GET("/queue/:id", q.GetRequest)
 		v2.DELETE("/queue/:id", q.DeleteRequest)
+		v2.POST("/queue/:id/retry", q.RetryRequest)
+		v2.POST("/queue/:id/requeue", q.RequeueRequest)
+		v2.POST("/queue/:id/cancel", q.CancelRequest)
+		v2.POST("/queue/:id/suspend", q.SuspendRequest)
+		v2.POST("/queue/:id/unsuspend", q.UnsuspendRequest)
+
+		u := new(controllers.UserController)
+		// managing users, roles and servers needs the sys_perms of the Users, Roles and Servers modules
+		readUsers := RequirePermission(models.ModuleUsers, models.PermRead)
+		addUsers := RequirePermission(models.ModuleUsers, models.PermAdd)
+		modifyUsers := RequirePermission(models.ModuleUsers, models.PermModify)
+		deleteUsers := RequirePermission(models.ModuleUsers, models.PermDelete)
+		v2.GET("/users", readUsers, u.Users)
+		v2.POST("/users", addUsers, u.CreateUser)
+		v2.GET("/users/:id", readUsers, u.GetUser)
+		v2.PUT("/users/:id", modifyUsers, u.UpdateUser)
+		v2.PATCH("/users/:id", modifyUsers, u.UpdateUser)
+		v2.DELETE("/users/:id", deleteUsers, u.DeleteUser)
+		v2.PUT("/users/:id/password", modifyUsers, u.ChangePassword)
+		v2.POST("/users/:id/activate", modifyUsers, u.ActivateUser)
+		v2.POST("/users/:id/deactivate", modifyUsers, u.DeactivateUser)
+
+		r := new(controllers.RoleController)
+		readRoles := RequirePermission(models.ModuleRoles, models.PermRead)
+		addRoles := RequirePermission(models.ModuleRoles, models.PermAdd)
+		modifyRoles := RequirePermission(models.ModuleRoles, models.PermModify)
+		deleteRoles := RequirePermission(models.ModuleRoles, models.PermDelete)
+		v2.GET("/roles", readRoles, r.Roles)
+		v2.POST("/roles", addRoles, r.CreateRole)
+		v2.GET("/roles/:id", readRoles, r.GetRole)
+		v2.PUT("/roles/:id", modifyRoles, r.UpdateRole)
+		v2.PATCH("/roles/:id", modifyRoles, r.UpdateRole)
+		v2.DELETE("/roles/:id", deleteRoles, r.DeleteRole)
+		v2.GET("/roles/:id/permissions", readRoles, r.Permissions)
+		v2.PUT("/roles/:id/permissions", modifyRoles, r.SetPermissions)
+
+		s := new(controllers.ServerController)
+		readServers := RequirePermission(models.ModuleServers, models.PermRead)
+		addServers := RequirePermission(models.ModuleServers, models.PermAdd)
+		modifyServers := RequirePermission(models.ModuleServers, models.PermModify)
+		deleteServers := RequirePermission(models.ModuleServers, models.PermDelete)
+		v2.GET("/servers", readServers, s.Servers)
+		v2.POST("/servers", addServers, s.CreateServer)
+		v2.GET("/servers/:id", readServers, s.GetServer)
+		v2.PUT("/servers/:id", modifyServers, s.UpdateServer)
+		v2.PATCH("/servers/:id", modifyServers, s.UpdateServer)
+		v2.DELETE("/servers/:id", deleteServers, s.DeleteServer)
+		v2.GET("/servers/:id/circuit", readServers, s.Circuit)
+		v2.POST("/servers/:id/circuit/open", modifyServers, s.OpenCircuit)
+		v2.POST("/servers/:id/circuit/close", modifyServers, s.CloseCircuit)
+		v2.GET("/servers/:id/sources", readServers, s.AllowedSources)
+		v2.PUT("/servers/:id/sources", modifyServers, s.SetAllowedSources)
+		v2.POST("/servers/:id/sources/:source", modifyServers, s.AddAllowedSource)
+		v2.DELETE("/servers/:id/sources/:source", modifyServers, s.RemoveAllowedSource)
+
+		// managing dead letters, priorities and callbacks needs the sys_perms of the Queue module
+		readQueue := RequirePermission(models.ModuleQueue, models.PermRead)
+		modifyQueue := RequirePermission(models.ModuleQueue, models.PermModify)
+		deleteQueue := RequirePermission(models.ModuleQueue, models.PermDelete)
+
+		d := new(controllers.DeadLetterController)
+		v2.GET("/deadletters", readQueue, d.DeadLetters)
+		v2.GET("/deadletters/summary", readQueue, d.Summary)
+		v2.POST("/deadletters/replay", modifyQueue, d.Replay)
+
+		p := new(controllers.PriorityController)
+		v2.GET("/priorities/report-types", readQueue, p.ReportTypePriorities)
+		v2.PUT("/priorities/report-types/:reportType", modifyQueue, p.SetReportTypePriority)
+		v2.DELETE("/priorities/report-types/:reportType", deleteQueue, p.DeleteReportTypePriority)
+
+		cb := new(controllers.CallbackController)
+		v2.GET("/callbacks", readQueue, cb.Callbacks)
+		v2.GET("/callbacks/:id", readQueue, cb.GetCallback)
+		v2.POST("/callbacks/:id/retry", modifyQueue, cb.RetryCallback)
+
+		b := new(controllers.BatchController)
+		v2.GET("/batches/:batchid", b.GetBatch)
 
 	}
 	// Handle error response when a route is not defined
@@ -599,7 +871,16 @@ func startAPIServer() {
 		c.String(404, "Page Not Found!")
 	})
 
-	router.Run(":" + fmt.Sprintf("%d", Dispatcher2Conf.ServerPort))
+	server := &http.Server{
+		Addr:    ":" + fmt.Sprintf("%d", Dispatcher2Conf.ServerPort),
+		Handler: router,
+	}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.WithError(err).Error("API server failed")
+		}
+	}()
+	return server
 }
 
 func startConsumers(jobs <-chan int, wg *sync.WaitGroup, app fyne.App) {
@@ -0,0 +1,105 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// RequestAction is an operator action that changes the state of a queued request
+type RequestAction string
+
+// constants for the request actions
+const (
+	RequestActionRetry     = RequestAction("retry")
+	RequestActionRequeue   = RequestAction("requeue")
+	RequestActionCancel    = RequestAction("cancel")
+	RequestActionSuspend   = RequestAction("suspend")
+	RequestActionUnsuspend = RequestAction("unsuspend")
+)
+
+// ErrRequestNotFound is returned when no request has the given uid
+var ErrRequestNotFound = errors.New("request not found")
+
+// TransitionError is returned when an action is not allowed for the current request status
+type TransitionError struct {
+	Action RequestAction
+	Status RequestStatus
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("cannot %s a request with status %s", e.Action, e.Status)
+}
+
+// requestTransition describes which statuses an action applies to and what it changes
+type requestTransition struct {
+	from []RequestStatus
+	set  string // the SET clause of the update
+}
+
+var requestTransitions = map[RequestAction]requestTransition{
+	RequestActionRetry: {
+		from: []RequestStatus{RequestStatusFailed, RequestStatusExpired, RequestStatusError},
+		set:  "status = 'ready', statuscode = '', errors = '', retries = 0, next_attempt_at = NULL",
+	},
+	RequestActionRequeue: {
+		from: []RequestStatus{
+			RequestStatusFailed, RequestStatusExpired, RequestStatusError,
+			RequestStatusCompleted, RequestStatusCanceled},
+		set: "status = 'ready', statuscode = '', errors = '', retries = 0, suspended = 0, next_attempt_at = NULL",
+	},
+	RequestActionCancel: {
+		from: []RequestStatus{RequestStatusReady, RequestStatusPending, RequestStatusFailed},
+		set:  "status = 'canceled', statuscode = 'CANCELED', errors = 'Canceled by user', next_attempt_at = NULL",
+	},
+	RequestActionSuspend: {
+		from: []RequestStatus{
+			RequestStatusReady, RequestStatusPending, RequestStatusFailed,
+			RequestStatusError, RequestStatusExpired},
+		set: "suspended = 1",
+	},
+	RequestActionUnsuspend: {
+		from: []RequestStatus{
+			RequestStatusReady, RequestStatusPending, RequestStatusFailed, RequestStatusError,
+			RequestStatusExpired, RequestStatusCompleted, RequestStatusCanceled},
+		set: "suspended = 0",
+	},
+}
+
+// ApplyRequestAction performs action on the request with the given uid and returns its new status
+func ApplyRequestAction(db *sqlx.DB, uid string, action RequestAction) (RequestStatus, error) {
+	t, ok := requestTransitions[action]
+	if !ok {
+		return "", fmt.Errorf("unknown request action: %s", action)
+	}
+	from := make([]string, len(t.from))
+	for i, s := range t.from {
+		from[i] = string(s)
+	}
+
+	var status RequestStatus
+	query := fmt.Sprintf(`
+UPDATE requests SET %s, updated = now()
+WHERE uid = $1 AND status = ANY($2)
+RETURNING status`, t.set)
+	err := db.Get(&status, query, uid, pq.Array(from))
+	if err == nil {
+		return status, nil
+	}
+	if err != sql.ErrNoRows {
+		return "", err
+	}
+
+	// nothing was updated, find out whether the request exists at all
+	err = db.Get(&status, "SELECT status FROM requests WHERE uid = $1", uid)
+	if err == sql.ErrNoRows {
+		return "", ErrRequestNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	return status, &TransitionError{Action: action, Status: status}
+}
@@ -0,0 +1,123 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gcinnovate/integrator/pages"
+	"github.com/jmoiron/sqlx"
+)
+
+// ErrNoAsyncJob is returned when the response to an async import does not reference a job
+var ErrNoAsyncJob = errors.New("response does not reference an import job")
+
+// AsyncJob is a DHIS2 import job started by a request to a server that uses async imports.
+// The request stays pending until the job finishes
+type AsyncJob struct {
+	RequestID   RequestID `db:"id"`
+	Destination ServerID  `db:"destination"`
+	ID          string    `db:"job_id"`
+	Type        string    `db:"job_type"` // e.g DATAVALUE_IMPORT, empty for tracker jobs
+	Location    string    `db:"job_location"`
+	Started     time.Time `db:"job_started_at"`
+}
+
+// ParseAsyncJob reads the job referenced by the response to an async import
+func ParseAsyncJob(body []byte) (AsyncJob, error) {
+	res := pages.AsyncResponse{}
+	if err := json.Unmarshal(body, &res); err != nil {
+		return AsyncJob{}, fmt.Errorf("%w: %s", ErrNoAsyncJob, err)
+	}
+	if res.Response.ID == "" {
+		return AsyncJob{}, ErrNoAsyncJob
+	}
+	job := AsyncJob{ID: res.Response.ID, Type: res.Response.JobType, Location: res.Response.Location}
+	if job.Location == "" {
+		job.Location = res.Response.RelativeNotifierEndpoint
+	}
+	if job.Type == "" && strings.Contains(job.Location, "/system/tasks/") {
+		// older servers only name the job type in the notifier endpoint
+		parts := strings.Split(strings.TrimSuffix(job.Location, "/"), "/")
+		if len(parts) >= 2 {
+			job.Type = parts[len(parts)-2]
+		}
+	}
+	return job, nil
+}
+
+// StatusURL returns the URL of the notifications of the job on server
+func (j *AsyncJob) StatusURL(server Server) (string, error) {
+	if j.Type == "" {
+		return server.APIURL("tracker/jobs/" + url.PathEscape(j.ID))
+	}
+	return server.APIURL("system/tasks/" + url.PathEscape(j.Type) + "/" + url.PathEscape(j.ID))
+}
+
+// ReportURL returns the URL of the import summary of the finished job on server
+func (j *AsyncJob) ReportURL(server Server) (string, error) {
+	if j.Type == "" {
+		return server.APIURL("tracker/jobs/" + url.PathEscape(j.ID) + "/report")
+	}
+	return server.APIURL("system/taskSummaries/" + url.PathEscape(j.Type) + "/" + url.PathEscape(j.ID))
+}
+
+// JobProgress reads the notifications of a job. It returns whether the job has completed and
+// the message of the latest error notification, if any
+func JobProgress(body []byte) (bool, string, error) {
+	notifications := []pages.TrackerJobImportResponse{}
+	if err := json.Unmarshal(body, &notifications); err != nil {
+		return false, "", err
+	}
+	completed, errMsg := false, ""
+	for _, n := range notifications { // the latest notification comes first
+		completed = completed || n.Completed
+		if errMsg == "" && strings.EqualFold(n.Level, "ERROR") {
+			errMsg = n.Message
+		}
+	}
+	return completed, errMsg, nil
+}
+
+// StartAsyncJob leaves the request with the given id pending on job
+func StartAsyncJob(db sqlx.Execer, id RequestID, job AsyncJob, response string) error {
+	_, err := db.Exec(`
+UPDATE requests SET
+	status = 'pending', response = $5, job_id = $2, job_type = $3, job_location = $4,
+	job_started_at = current_timestamp, job_checked_at = NULL, lease_expires_at = NULL,
+	next_attempt_at = NULL, updated = current_timestamp
+WHERE id = $1`, id, job.ID, job.Type, job.Location, response)
+	return err
+}
+
+// DueAsyncJobs returns the jobs of pending requests not checked within the last interval
+func DueAsyncJobs(db sqlx.Queryer, interval time.Duration) ([]AsyncJob, error) {
+	jobs := []AsyncJob{}
+	err := sqlx.Select(db, &jobs, `
+SELECT id, destination, job_id, COALESCE(job_type, '') AS job_type, COALESCE(job_location, '') AS job_location,
+	job_started_at
+FROM requests
+WHERE status = 'pending' AND job_id IS NOT NULL
+	AND (job_checked_at IS NULL OR job_checked_at <= current_timestamp - $1 * INTERVAL '1 second')
+ORDER BY job_checked_at NULLS FIRST`, int(interval/time.Second))
+	return jobs, err
+}
+
+// CheckedAsyncJob records that the job of the request with the given id was checked and is not finished
+func CheckedAsyncJob(db sqlx.Execer, id RequestID) error {
+	_, err := db.Exec("UPDATE requests SET job_checked_at = current_timestamp WHERE id = $1", id)
+	return err
+}
+
+// FinishAsyncJob sets the final status of the request with the given id once its job has finished
+func FinishAsyncJob(db sqlx.Execer, id RequestID, status RequestStatus, statusCode, errors, response string) error {
+	_, err := db.Exec(`
+UPDATE requests SET
+	status = $2, statuscode = $3, errors = $4, response = $5, job_checked_at = current_timestamp,
+	updated = current_timestamp
+WHERE id = $1 AND status = 'pending'`, id, status, statusCode, errors, response)
+	return err
+}
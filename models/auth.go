@@ -0,0 +1,272 @@
+package models
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gcinnovate/integrator/pages"
+)
+
+// Authenticator adds the credentials of a server to the requests sent to it. Authenticators
+// are registered by auth_method with RegisterAuthenticator
+type Authenticator interface {
+	// Authenticate adds the credentials of server to req
+	Authenticate(req *http.Request, server *Server) error
+	// Check reports the settings server is missing for the method
+	Check(server *Server) []FieldError
+}
+
+// authInvalidator is implemented by authenticators that cache credentials, such as OAuth2 tokens
+type authInvalidator interface {
+	// Invalidate drops what is cached for server after the server rejected it
+	Invalidate(server *Server)
+}
+
+var (
+	authenticatorsMu sync.RWMutex
+	authenticators   = map[string]Authenticator{}
+)
+
+// RegisterAuthenticator makes an auth_method available to servers. It is meant to be called
+// from init functions, registering a method again replaces it
+func RegisterAuthenticator(method string, a Authenticator) {
+	authenticatorsMu.Lock()
+	defer authenticatorsMu.Unlock()
+	authenticators[method] = a
+}
+
+// lookupAuthenticator returns the authenticator of method, Basic when method is empty
+func lookupAuthenticator(method string) (Authenticator, bool) {
+	if method == "" {
+		method = AuthBasic
+	}
+	authenticatorsMu.RLock()
+	defer authenticatorsMu.RUnlock()
+	a, ok := authenticators[method]
+	return a, ok
+}
+
+// AuthMethods returns the registered auth methods in alphabetical order
+func AuthMethods() []string {
+	authenticatorsMu.RLock()
+	defer authenticatorsMu.RUnlock()
+	methods := make([]string, 0, len(authenticators))
+	for method := range authenticators {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// ErrAuthentication wraps the errors of authenticators. These come from the configuration of
+// the server or its token endpoint, not from the server itself
+var ErrAuthentication = errors.New("failed to authenticate")
+
+// Authenticate adds the credentials of the server to req with the authenticator of its
+// auth_method. Servers with an auth_method that is not registered use Basic auth
+func (s *Server) Authenticate(req *http.Request) error {
+	a, ok := lookupAuthenticator(s.s.AuthMethod)
+	if !ok {
+		a = basicAuth{}
+	}
+	if err := a.Authenticate(req, s); err != nil {
+		return fmt.Errorf("%w: %v", ErrAuthentication, err)
+	}
+	return nil
+}
+
+// InvalidateAuth drops the cached credentials of the server. It is called when the server
+// answers 401 so that the next request authenticates afresh
+func (s *Server) InvalidateAuth() {
+	if a, ok := lookupAuthenticator(s.s.AuthMethod); ok {
+		if i, ok := a.(authInvalidator); ok {
+			i.Invalidate(s)
+		}
+	}
+}
+
+// trackerAuthMethods maps the auth methods offered on the tracker tab to the registered ones
+var trackerAuthMethods = map[string]string{
+	"Basic Authentication":  AuthBasic,
+	"Personal Access Token": AuthToken,
+	"JSON Web Token":        AuthJWT,
+}
+
+// AuthenticateTrackerRequest adds the credentials chosen on the tracker tab to req with the
+// registered authenticators
+func AuthenticateTrackerRequest(req *http.Request, c pages.Credentials) error {
+	method, ok := trackerAuthMethods[c.AuthMethod]
+	if !ok {
+		method = AuthBasic
+	}
+	server := Server{s: serverFields{
+		Name: "tracker", AuthMethod: method, Username: c.Username, Password: c.Password, AuthToken: c.Token}}
+	return server.Authenticate(req)
+}
+
+// AuthParams are the settings of the auth_method of a server
+type AuthParams map[string]string
+
+// Value implements the driver.Valuer interface for the auth_params JSONB column
+func (p AuthParams) Value() (driver.Value, error) {
+	if p == nil {
+		return "{}", nil
+	}
+	b, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Scan implements the sql.Scanner interface for the auth_params JSONB column
+func (p *AuthParams) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case nil:
+		*p = AuthParams{}
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return fmt.Errorf("cannot scan %T into AuthParams", src)
+	}
+	params := AuthParams{}
+	if err := json.Unmarshal(data, &params); err != nil {
+		return err
+	}
+	*p = params
+	return nil
+}
+
+// authParam returns the auth param name of the server, or fallback when it is not set
+func (s *Server) authParam(name, fallback string) string {
+	if v := strings.TrimSpace(s.s.AuthParams[name]); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// the built in auth methods
+const (
+	AuthBasic  = "Basic Auth"
+	AuthToken  = "Token" // DHIS2 personal access token
+	AuthBearer = "Bearer"
+	AuthJWT    = "JSON Web Token" // a Bearer token, as offered by the tracker settings
+	AuthAPIKey = "API Key"
+	AuthHMAC   = "HMAC"
+	AuthOAuth2 = "OAuth2 Client Credentials"
+)
+
+func init() {
+	RegisterAuthenticator(AuthBasic, basicAuth{})
+	RegisterAuthenticator(AuthToken, headerTokenAuth{scheme: "ApiToken"})
+	RegisterAuthenticator(AuthBearer, headerTokenAuth{scheme: "Bearer"})
+	RegisterAuthenticator(AuthJWT, headerTokenAuth{scheme: "Bearer"})
+	RegisterAuthenticator(AuthAPIKey, apiKeyAuth{})
+	RegisterAuthenticator(AuthHMAC, hmacAuth{})
+	RegisterAuthenticator(AuthOAuth2, newOAuth2Auth())
+}
+
+// basicAuth sends the username and password of the server
+type basicAuth struct{}
+
+func (basicAuth) Authenticate(req *http.Request, server *Server) error {
+	req.SetBasicAuth(server.s.Username, server.s.Password)
+	return nil
+}
+
+func (basicAuth) Check(server *Server) []FieldError { return nil }
+
+// headerTokenAuth sends the auth_token of the server in the Authorization header
+type headerTokenAuth struct {
+	scheme string
+}
+
+func (a headerTokenAuth) Authenticate(req *http.Request, server *Server) error {
+	req.Header.Set("Authorization", a.scheme+" "+server.s.AuthToken)
+	return nil
+}
+
+func (a headerTokenAuth) Check(server *Server) []FieldError {
+	var errs fieldErrors
+	if server.s.AuthToken == "" {
+		errs.add("auth_token", "is required when auth_method is "+server.s.AuthMethod)
+	}
+	return errs
+}
+
+// apiKeyAuth sends the auth_token of the server in the header named by the header auth param,
+// X-API-Key by default, optionally after the prefix auth param
+type apiKeyAuth struct{}
+
+func (apiKeyAuth) Authenticate(req *http.Request, server *Server) error {
+	value := server.s.AuthToken
+	if prefix := server.authParam("prefix", ""); prefix != "" {
+		value = prefix + " " + value
+	}
+	req.Header.Set(server.authParam("header", "X-API-Key"), value)
+	return nil
+}
+
+func (apiKeyAuth) Check(server *Server) []FieldError {
+	return headerTokenAuth{}.Check(server)
+}
+
+// hmacAuth signs requests with HMAC-SHA256 keyed with the auth_token of the server. The
+// signature covers the method, the path and query, the unix timestamp and the body, each
+// on a line of its own, and is sent hex encoded in the header auth param (X-Signature) with
+// the timestamp in the timestampHeader auth param (X-Timestamp). The username, when set, is
+// sent as the key id in the keyIdHeader auth param (X-Key-Id)
+type hmacAuth struct{}
+
+func (hmacAuth) Authenticate(req *http.Request, server *Server) error {
+	var body []byte
+	if req.GetBody != nil {
+		rc, err := req.GetBody()
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		if body, err = io.ReadAll(rc); err != nil {
+			return err
+		}
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(server.s.AuthToken))
+	fmt.Fprintf(mac, "%s\n%s\n%s\n", req.Method, req.URL.RequestURI(), timestamp)
+	mac.Write(body)
+	req.Header.Set(server.authParam("timestampHeader", "X-Timestamp"), timestamp)
+	req.Header.Set(server.authParam("header", "X-Signature"), hex.EncodeToString(mac.Sum(nil)))
+	if server.s.Username != "" {
+		req.Header.Set(server.authParam("keyIdHeader", "X-Key-Id"), server.s.Username)
+	}
+	return nil
+}
+
+func (hmacAuth) Check(server *Server) []FieldError {
+	return headerTokenAuth{}.Check(server)
+}
+
+// drainBody reads and closes a response body, keeping up to limit bytes
+func drainBody(body io.ReadCloser, limit int64) []byte {
+	defer body.Close()
+	var buf bytes.Buffer
+	_, _ = io.Copy(&buf, io.LimitReader(body, limit))
+	return buf.Bytes()
+}
@@ -0,0 +1,40 @@
+package models
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy is the exponential backoff used to schedule retries of failed requests
+type RetryPolicy struct {
+	BaseDelay  time.Duration // delay before the first retry
+	MaxDelay   time.Duration // upper bound of the delay
+	Multiplier float64       // growth of the delay with each retry
+	Jitter     float64       // fraction of the delay that is randomized, between 0 and 1
+}
+
+// DefaultRetryPolicy matches the column defaults of the servers table
+var DefaultRetryPolicy = RetryPolicy{
+	BaseDelay:  30 * time.Second,
+	MaxDelay:   time.Hour,
+	Multiplier: 2,
+	Jitter:     0.2,
+}
+
+// Delay returns how long to wait before the given retry, counting from 1.
+// The delay grows by Multiplier per retry up to MaxDelay and is spread by ±Jitter
+// so that requests failing together are not all retried at the same moment
+func (p RetryPolicy) Delay(retry int) time.Duration {
+	if retry < 1 {
+		retry = 1
+	}
+	delay := float64(p.BaseDelay) * math.Pow(math.Max(p.Multiplier, 1), float64(retry-1))
+	if p.MaxDelay > 0 && delay > float64(p.MaxDelay) {
+		delay = float64(p.MaxDelay)
+	}
+	if p.Jitter > 0 {
+		delay += delay * p.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(delay)
+}
@@ -0,0 +1,104 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ErrBatchNotFound is returned when no request belongs to a batch
+var ErrBatchNotFound = errors.New("batch not found")
+
+// maxBatchFailures limits the number of failed requests listed in a batch summary
+const maxBatchFailures = 1000
+
+// BatchFailure is a failed request in a batch
+type BatchFailure struct {
+	UID        string        `db:"uid" json:"uid"`
+	Status     RequestStatus `db:"status" json:"status"`
+	StatusCode string        `db:"statuscode" json:"statusCode"`
+	Errors     string        `db:"errors" json:"errors"`
+	Retries    int           `db:"retries" json:"retries"`
+	Updated    time.Time     `db:"updated" json:"updated"`
+}
+
+// BatchSummary reports the progress of the requests sharing a batchid. Copies to cc_urls keep
+// the batchid of the request they copy, they are left out of the totals and counted on their own
+type BatchSummary struct {
+	BatchID      string                `db:"batchid" json:"batchId"`
+	Total        int                   `db:"total" json:"total"`
+	Retries      int                   `db:"retries" json:"retries"`
+	FirstCreated time.Time             `db:"first_created" json:"firstCreated"`
+	LastCreated  time.Time             `db:"last_created" json:"lastCreated"`
+	FirstUpdated time.Time             `db:"first_updated" json:"firstUpdated"`
+	LastUpdated  time.Time             `db:"last_updated" json:"lastUpdated"`
+	Statuses     map[RequestStatus]int `db:"-" json:"statuses"`
+	FailedCount  int                   `db:"-" json:"failedCount"`
+	Failed       []BatchFailure        `db:"-" json:"failed"`
+	Copies       int                   `db:"-" json:"copies"`
+	FailedCopies int                   `db:"-" json:"failedCopies"`
+}
+
+// GetBatchSummary returns the summary of the batch with the given batchid
+func GetBatchSummary(db *sqlx.DB, batchID string) (BatchSummary, error) {
+	summary := BatchSummary{BatchID: batchID}
+	if batchID == "" {
+		return summary, ErrBatchNotFound
+	}
+	err := db.Get(&summary, `
+SELECT
+	batchid, COUNT(*) AS total, COALESCE(SUM(retries), 0) AS retries,
+	MIN(created) AS first_created, MAX(created) AS last_created,
+	MIN(updated) AS first_updated, MAX(updated) AS last_updated
+FROM requests
+WHERE batchid = $1 AND parent_id IS NULL
+GROUP BY batchid`, batchID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return summary, ErrBatchNotFound
+		}
+		return summary, err
+	}
+
+	rows, err := db.Queryx(`
+SELECT status, COUNT(*) FROM requests WHERE batchid = $1 AND parent_id IS NULL GROUP BY status`, batchID)
+	if err != nil {
+		return summary, err
+	}
+	defer rows.Close()
+	summary.Statuses = make(map[RequestStatus]int)
+	for rows.Next() {
+		var status RequestStatus
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return summary, err
+		}
+		summary.Statuses[status] = count
+	}
+	if err := rows.Err(); err != nil {
+		return summary, err
+	}
+
+	failedStatuses := []RequestStatus{RequestStatusFailed, RequestStatusError, RequestStatusExpired}
+	for _, s := range failedStatuses {
+		summary.FailedCount += summary.Statuses[s]
+	}
+	summary.Failed = []BatchFailure{}
+	err = db.Select(&summary.Failed, `
+SELECT uid, status, COALESCE(statuscode, '') AS statuscode, COALESCE(errors, '') AS errors, retries, updated
+FROM requests
+WHERE batchid = $1 AND parent_id IS NULL AND status IN ('failed', 'error', 'expired')
+ORDER BY updated DESC
+LIMIT $2`, batchID, maxBatchFailures)
+	if err != nil {
+		return summary, err
+	}
+
+	err = db.QueryRowx(`
+SELECT COUNT(*), COUNT(*) FILTER (WHERE status IN ('failed', 'error', 'expired'))
+FROM requests
+WHERE batchid = $1 AND parent_id IS NOT NULL`, batchID).Scan(&summary.Copies, &summary.FailedCopies)
+	return summary, err
+}
@@ -0,0 +1,190 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/gcinnovate/integrator/utils"
+	"github.com/jmoiron/sqlx"
+)
+
+// RequestEnvelope is a single request as submitted to the bulk queue endpoint.
+// The fields mirror the query parameters accepted by POST /api/queue
+type RequestEnvelope struct {
+	Source        string          `json:"source"`
+	Destination   string          `json:"destination"`
+	ContentType   string          `json:"contentType"`
+	ObjectType    string          `json:"objectType"`
+	ReportType    string          `json:"reportType"`
+	BatchID       string          `json:"batchId"`
+	SubmissionID  string          `json:"submissionId"`
+	Period        string          `json:"period"`
+	Week          string          `json:"week"`
+	Month         string          `json:"month"`
+	Year          string          `json:"year"`
+	MSISDN        string          `json:"msisdn"`
+	RawMsg        string          `json:"rawMsg"`
+	Facility      string          `json:"facility"`
+	District      string          `json:"district"`
+	Extras        string          `json:"extras"`
+	URLSuffix     string          `json:"urlSuffix"`
+	IsQueryParams bool            `json:"isQueryParams"`
+	Priority      *int            `json:"priority"` // defaults to the priority of the report type or source
+	Body          json.RawMessage `json:"body"`
+}
+
+// BulkRequestResult is the outcome of queuing one envelope in a bulk submission
+type BulkRequestResult struct {
+	Index        int           `json:"index"`
+	UID          string        `json:"uid,omitempty"`
+	Status       RequestStatus `json:"status,omitempty"`
+	SubmissionID string        `json:"submissionId,omitempty"`
+	Deduplicated bool          `json:"deduplicated"`
+	Errors       []string      `json:"errors,omitempty"`
+	FieldErrors  []FieldError  `json:"fieldErrors,omitempty"`
+}
+
+// ToRequest validates the envelope and returns the request it describes
+func (e *RequestEnvelope) ToRequest() (Request, []string) {
+	var errs []string
+	req := Request{}
+	r := &req.r
+
+	if e.Source == "" {
+		errs = append(errs, "source is required")
+	}
+	if e.Destination == "" {
+		errs = append(errs, "destination is required")
+	}
+	r.ContentType = e.ContentType
+	if r.ContentType == "" {
+		r.ContentType = "application/json"
+	}
+	body, err := e.body(r.ContentType)
+	if err != nil {
+		errs = append(errs, err.Error())
+	} else if e.IsQueryParams {
+		if _, err := BodyQueryParams(body, r.ContentType); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if err := ValidURLSuffix(e.URLSuffix); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if e.Priority != nil && (*e.Priority < MinPriority || *e.Priority > MaxPriority) {
+		errs = append(errs, fmt.Sprintf("priority must be between %d and %d", MinPriority, MaxPriority))
+	}
+	if len(errs) > 0 {
+		return req, errs
+	}
+
+	r.Source = utils.GetServer(e.Source)
+	r.Destination = utils.GetServer(e.Destination)
+	r.UID = utils.GetUID()
+	r.Body = body
+	r.SubmissionID = e.SubmissionID
+	r.BatchID = e.BatchID
+	r.Period = e.Period
+	r.Week = e.Week
+	r.Month = e.Month
+	r.Year = e.Year
+	r.MSISDN = e.MSISDN
+	r.Facility = e.Facility
+	r.District = e.District
+	r.RawMsg = e.RawMsg
+	r.BodyIsQueryParams = e.IsQueryParams
+	r.ReportType = e.ReportType
+	r.ObjectType = e.ObjectType
+	r.Extras = e.Extras
+	r.URLSuffix = e.URLSuffix
+	r.Priority = e.Priority
+	r.Status = RequestStatusReady
+	return req, nil
+}
+
+// body returns the envelope body as it is stored in the requests table
+func (e *RequestEnvelope) body(contentType string) (string, error) {
+	return storedBody(e.Body, contentType)
+}
+
+// storedBody returns a body given in a JSON document as it is stored in the requests table.
+// JSON bodies are embedded as is, any other content type is sent as a JSON string
+func storedBody(body json.RawMessage, contentType string) (string, error) {
+	raw := bytes.TrimSpace(body)
+	if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+		return "", fmt.Errorf("body is required")
+	}
+	if MediaType(contentType) == "application/json" {
+		var compacted bytes.Buffer
+		if err := json.Compact(&compacted, raw); err != nil {
+			return "", fmt.Errorf("body is not valid JSON: %v", err)
+		}
+		return compacted.String(), nil
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return "", fmt.Errorf("body for content type %s must be a JSON string", contentType)
+	}
+	return s, nil
+}
+
+// NewRequests queues the envelopes in a single transaction. Envelopes that fail validation
+// or cannot be inserted are reported in their result and do not affect the others
+func NewRequests(db *sqlx.DB, envelopes []RequestEnvelope) ([]BulkRequestResult, error) {
+	results := make([]BulkRequestResult, len(envelopes))
+	tx, err := db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	for i := range envelopes {
+		result := &results[i]
+		result.Index = i
+		req, errs := envelopes[i].ToRequest()
+		if len(errs) > 0 {
+			result.Errors = errs
+			continue
+		}
+		if err := ValidateBody(req.ObjectType(), req.ContentType(), []byte(req.Body())); err != nil {
+			result.Errors = []string{err.Error()}
+			var validationErr *ValidationError
+			if errors.As(err, &validationErr) {
+				result.FieldErrors = validationErr.Errors
+			}
+			continue
+		}
+		if err := checkRoute(tx, req.Source(), req.Destination(),
+			envelopes[i].Source, envelopes[i].Destination); err != nil {
+			if !IsRouteError(err) {
+				return nil, err
+			}
+			result.Errors = []string{err.Error()}
+			continue
+		}
+		// a savepoint per item keeps one bad insert from aborting the whole transaction
+		if _, err := tx.Exec("SAVEPOINT bulk_request"); err != nil {
+			return nil, err
+		}
+		if err := req.insert(tx); err != nil {
+			if _, rbErr := tx.Exec("ROLLBACK TO SAVEPOINT bulk_request"); rbErr != nil {
+				return nil, rbErr
+			}
+			result.Errors = []string{err.Error()}
+			continue
+		}
+		if _, err := tx.Exec("RELEASE SAVEPOINT bulk_request"); err != nil {
+			return nil, err
+		}
+		result.UID = req.UID()
+		result.Status = req.Status()
+		result.SubmissionID = req.SubmissionID()
+		result.Deduplicated = req.Deduplicated()
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
@@ -0,0 +1,241 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// CallbackStatus is the status of the delivery of a callback
+type CallbackStatus string
+
+// the statuses of a callback delivery
+const (
+	CallbackPending   = CallbackStatus("pending")
+	CallbackDelivered = CallbackStatus("delivered")
+	CallbackFailed    = CallbackStatus("failed")
+)
+
+// ErrCallbackNotFound is returned when a callback delivery does not exist
+var ErrCallbackNotFound = errors.New("callback delivery not found")
+
+// CallbackDelivery is the callback posted to the source of a request once the request
+// reaches a final status. Deliveries are queued by the requests_queue_callback trigger
+type CallbackDelivery struct {
+	ID            int64          `db:"id" json:"id"`
+	RequestID     RequestID      `db:"request_id" json:"-"`
+	RequestUID    string         `db:"request_uid" json:"requestUid"`
+	Server        ServerID       `db:"server_id" json:"server"`
+	URL           string         `db:"url" json:"url"`
+	Status        CallbackStatus `db:"status" json:"status"`
+	RequestStatus RequestStatus  `db:"request_status" json:"requestStatus"`
+	StatusCode    string         `db:"statuscode" json:"statuscode"`
+	Errors        string         `db:"errors" json:"errors"`
+	Attempts      int            `db:"attempts" json:"attempts"`
+	NextAttemptAt *time.Time     `db:"next_attempt_at" json:"nextAttemptAt"`
+	DeliveredAt   *time.Time     `db:"delivered_at" json:"deliveredAt"`
+	Created       time.Time      `db:"created" json:"created"`
+	Updated       time.Time      `db:"updated" json:"updated"`
+}
+
+// CallbackAttempt is one attempt to deliver a callback
+type CallbackAttempt struct {
+	ResponseCode *int      `db:"response_code" json:"responseCode"`
+	Response     string    `db:"response" json:"response"`
+	Error        string    `db:"error" json:"error"`
+	Created      time.Time `db:"created" json:"created"`
+}
+
+// CallbackPayload is the body posted to the callback_url
+type CallbackPayload struct {
+	UID          string        `db:"uid" json:"uid"`
+	SubmissionID string        `db:"submissionid" json:"submissionId"`
+	BatchID      string        `db:"batchid" json:"batchId"`
+	Status       RequestStatus `db:"request_status" json:"status"`
+	StatusCode   string        `db:"statuscode" json:"statuscode"`
+	Errors       string        `db:"errors" json:"errors"`
+	ImportCount  *ImportCount  `db:"-" json:"importCount,omitempty"`
+}
+
+// DueCallback is a claimed callback delivery with the payload to post
+type DueCallback struct {
+	ID       int64           `db:"id"`
+	Server   ServerID        `db:"server_id"`
+	URL      string          `db:"url"`
+	Attempts int             `db:"attempts"`
+	Response string          `db:"response"` // final response of the request
+	Payload  CallbackPayload `db:"-"`
+}
+
+// CallbackFilter selects callback deliveries. Zero values match everything
+type CallbackFilter struct {
+	Status     CallbackStatus
+	RequestUID string
+	Server     ServerID
+}
+
+const selectCallbackSQL = `
+SELECT
+	d.id, d.request_id, r.uid AS request_uid, d.server_id, d.url, d.status, d.request_status,
+	d.statuscode, d.errors, d.attempts,
+	CASE WHEN d.status = 'pending' THEN d.next_attempt_at END AS next_attempt_at,
+	d.delivered_at, d.created, d.updated
+FROM callback_deliveries d
+INNER JOIN requests r ON r.id = d.request_id`
+
+// ValidCallbackStatus returns whether status is a status of a callback delivery
+func ValidCallbackStatus(status CallbackStatus) bool {
+	return status == CallbackPending || status == CallbackDelivered || status == CallbackFailed
+}
+
+// GetCallbacks returns a page of the callback deliveries matching filter, most recent first,
+// together with the number of matching deliveries
+func GetCallbacks(db sqlx.Queryer, filter CallbackFilter, page, pageSize int) ([]CallbackDelivery, int, error) {
+	conds := []string{"TRUE"}
+	args := []interface{}{}
+	if filter.Status != "" {
+		args = append(args, filter.Status)
+		conds = append(conds, fmt.Sprintf("d.status = $%d", len(args)))
+	}
+	if filter.RequestUID != "" {
+		args = append(args, filter.RequestUID)
+		conds = append(conds, fmt.Sprintf("r.uid = $%d", len(args)))
+	}
+	if filter.Server != 0 {
+		args = append(args, filter.Server)
+		conds = append(conds, fmt.Sprintf("d.server_id = $%d", len(args)))
+	}
+	where := strings.Join(conds, " AND ")
+	var total int
+	err := sqlx.Get(db, &total, `
+SELECT COUNT(*) FROM callback_deliveries d INNER JOIN requests r ON r.id = d.request_id
+WHERE `+where, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	args = append(args, pageSize, (page-1)*pageSize)
+	callbacks := []CallbackDelivery{}
+	err = sqlx.Select(db, &callbacks, fmt.Sprintf(selectCallbackSQL+`
+WHERE %s
+ORDER BY d.created DESC
+LIMIT $%d OFFSET $%d`, where, len(args)-1, len(args)), args...)
+	return callbacks, total, err
+}
+
+// GetCallback returns the callback delivery with the given id and its attempts, latest first
+func GetCallback(db sqlx.Queryer, id int64) (CallbackDelivery, []CallbackAttempt, error) {
+	callback := CallbackDelivery{}
+	err := sqlx.Get(db, &callback, selectCallbackSQL+" WHERE d.id = $1", id)
+	if err == sql.ErrNoRows {
+		return callback, nil, fmt.Errorf("%w: %d", ErrCallbackNotFound, id)
+	}
+	if err != nil {
+		return callback, nil, err
+	}
+	attempts := []CallbackAttempt{}
+	err = sqlx.Select(db, &attempts, `
+SELECT response_code, response, error, created FROM callback_attempts
+WHERE delivery_id = $1
+ORDER BY created DESC`, id)
+	return callback, attempts, err
+}
+
+// RetryCallback queues the callback delivery with the given id to be delivered again now
+func RetryCallback(db sqlx.Queryer, id int64) (CallbackDelivery, error) {
+	var updated int64
+	err := sqlx.Get(db, &updated, `
+UPDATE callback_deliveries SET
+	status = 'pending', attempts = 0, next_attempt_at = current_timestamp, updated = current_timestamp
+WHERE id = $1
+RETURNING id`, id)
+	if err == sql.ErrNoRows {
+		return CallbackDelivery{}, fmt.Errorf("%w: %d", ErrCallbackNotFound, id)
+	}
+	if err != nil {
+		return CallbackDelivery{}, err
+	}
+	callback, _, err := GetCallback(db, id)
+	return callback, err
+}
+
+// claimCallbacksSQL reserves up to $1 due callback deliveries for $2 seconds by moving their
+// next attempt forward, so that a delivery is attempted by one dispatcher at a time
+const claimCallbacksSQL = `
+UPDATE callback_deliveries d SET
+	next_attempt_at = current_timestamp + $2 * INTERVAL '1 second',
+	attempts = d.attempts + 1,
+	updated = current_timestamp
+FROM requests r
+WHERE r.id = d.request_id AND d.id IN (
+	SELECT id FROM callback_deliveries
+	WHERE status = 'pending' AND next_attempt_at <= current_timestamp
+	ORDER BY next_attempt_at
+	LIMIT $1
+	FOR UPDATE SKIP LOCKED)
+RETURNING
+	d.id, d.server_id, d.url, d.attempts, COALESCE(r.response, '') AS response, r.uid,
+	r.submissionid, r.batchid, d.request_status, d.statuscode, d.errors`
+
+// ClaimCallbacks claims up to limit callback deliveries that are due, counting an attempt
+// for each. The payload carries the import counts of the final response of the request
+func ClaimCallbacks(db sqlx.Queryer, limit int, lease time.Duration) ([]DueCallback, error) {
+	rows, err := db.Queryx(claimCallbacksSQL, limit, int(lease/time.Second))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	callbacks := []DueCallback{}
+	for rows.Next() {
+		var row struct {
+			DueCallback
+			CallbackPayload
+		}
+		if err := rows.StructScan(&row); err != nil {
+			return nil, err
+		}
+		callback := row.DueCallback
+		callback.Payload = row.CallbackPayload
+		if outcome, ok := InterpretImportSummary([]byte(callback.Response)); ok {
+			callback.Payload.ImportCount = &outcome.Count
+		}
+		callbacks = append(callbacks, callback)
+	}
+	return callbacks, rows.Err()
+}
+
+// RecordCallbackAttempt logs an attempt to deliver a callback. A nil responseCode means the
+// callback_url could not be reached
+func RecordCallbackAttempt(db sqlx.Execer, id int64, responseCode *int, response, errMsg string) error {
+	_, err := db.Exec(`
+INSERT INTO callback_attempts (delivery_id, response_code, response, error) VALUES ($1, $2, $3, $4)`,
+		id, responseCode, response, errMsg)
+	return err
+}
+
+// CallbackDeliveredTo marks the callback delivery with the given id as delivered
+func CallbackDeliveredTo(db sqlx.Execer, id int64) error {
+	_, err := db.Exec(`
+UPDATE callback_deliveries SET
+	status = 'delivered', delivered_at = current_timestamp, updated = current_timestamp
+WHERE id = $1`, id)
+	return err
+}
+
+// CallbackNotDelivered schedules the next attempt of the callback delivery with the given id
+// after the backoff of policy, or fails the delivery once maxAttempts have been made
+func CallbackNotDelivered(db sqlx.Execer, id int64, attempts, maxAttempts int, policy RetryPolicy) error {
+	if attempts >= maxAttempts {
+		_, err := db.Exec(`
+UPDATE callback_deliveries SET status = 'failed', updated = current_timestamp WHERE id = $1`, id)
+		return err
+	}
+	_, err := db.Exec(`
+UPDATE callback_deliveries SET
+	next_attempt_at = current_timestamp + $2 * INTERVAL '1 second', updated = current_timestamp
+WHERE id = $1`, id, policy.Delay(attempts).Seconds())
+	return err
+}
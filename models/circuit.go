@@ -0,0 +1,177 @@
+package models
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+)
+
+// CircuitState is the state of the circuit breaker of a server
+type CircuitState string
+
+// constants for the circuit states
+const (
+	CircuitClosed = CircuitState("closed") // requests are dispatched
+	CircuitOpen   = CircuitState("open")   // requests are held until a probe succeeds
+)
+
+// CircuitOpen returns whether the circuit breaker of the server was open when the servers were
+// loaded. Circuit changes do not reload the servers, the claim of requests reads the current state
+func (s *Server) CircuitOpen() bool { return s.s.CircuitState == CircuitOpen }
+
+// CircuitState returns the state of the circuit breaker of the server
+func (s *Server) CircuitState() CircuitState { return s.s.CircuitState }
+
+// CircuitFailures returns the consecutive failures counted by the circuit breaker of this
+// instance, or the failures that opened the circuit
+func (s *Server) CircuitFailures() int {
+	if s.s.CircuitState == CircuitOpen {
+		return s.s.CircuitFailures
+	}
+	circuits.Lock()
+	defer circuits.Unlock()
+	return circuits.failures[s.ID()]
+}
+
+// CircuitFailureThreshold returns the consecutive failures that open the circuit, 0 disables it
+func (s *Server) CircuitFailureThreshold() int { return s.s.CircuitFailureThreshold }
+
+// CircuitOpenedAt returns when the circuit was opened
+func (s *Server) CircuitOpenedAt() *time.Time { return s.s.CircuitOpenedAt }
+
+// CircuitProbedAt returns when this instance last probed the server while its circuit was open
+func (s *Server) CircuitProbedAt() *time.Time {
+	circuits.Lock()
+	defer circuits.Unlock()
+	if t, ok := circuits.probedAt[s.ID()]; ok {
+		return &t
+	}
+	return nil
+}
+
+// ProbeURL returns the URL checked to find out whether the server is back. DHIS2 servers
+// default to /api/system/ping, other servers to their URL
+func (s *Server) ProbeURL() string {
+	if s.s.CircuitProbeURL != "" {
+		return s.s.CircuitProbeURL
+	}
+	if strings.EqualFold(s.s.SystemType, "DHIS2") {
+		if u, err := s.APIURL("system/ping"); err == nil {
+			return u
+		}
+	}
+	return s.s.URL
+}
+
+// circuits keeps the consecutive failures and the last probe of each server in the process,
+// so that successful sends and probes do not write to the servers table. Only the opening and
+// closing of a circuit is stored, and with several instances each one counts and probes on its own
+var circuits = struct {
+	sync.Mutex
+	failures map[ServerID]int
+	probedAt map[ServerID]time.Time
+}{failures: make(map[ServerID]int), probedAt: make(map[ServerID]time.Time)}
+
+// RecordServerFailure counts a connection failure or 5xx response of the server and opens its
+// circuit once CircuitFailureThreshold consecutive failures are reached. It returns whether
+// the circuit is open afterwards
+func RecordServerFailure(db sqlx.Execer, server Server) (bool, error) {
+	id := server.ID()
+	circuits.Lock()
+	circuits.failures[id]++
+	failures := circuits.failures[id]
+	circuits.Unlock()
+	threshold := server.CircuitFailureThreshold()
+	if threshold <= 0 || failures < threshold {
+		return false, nil
+	}
+	res, err := db.Exec(`
+UPDATE servers SET circuit_state = 'open', circuit_failures = $2, circuit_opened_at = current_timestamp
+WHERE id = $1 AND circuit_state = 'closed'`, id, failures)
+	if err != nil {
+		return false, err
+	}
+	resetCircuit(id)
+	if n, _ := res.RowsAffected(); n > 0 {
+		log.WithFields(log.Fields{"server": id, "failures": failures}).Warn("Circuit opened for server")
+	}
+	return true, nil
+}
+
+// RecordServerSuccess resets the consecutive failures of the server
+func RecordServerSuccess(id ServerID) {
+	circuits.Lock()
+	delete(circuits.failures, id)
+	circuits.Unlock()
+}
+
+// resetCircuit forgets the failures and last probe of the server when its circuit changes state
+func resetCircuit(id ServerID) {
+	circuits.Lock()
+	delete(circuits.failures, id)
+	delete(circuits.probedAt, id)
+	circuits.Unlock()
+}
+
+// CloseCircuit closes the circuit of the server so that its requests are dispatched again
+func CloseCircuit(db sqlx.Execer, id ServerID) error {
+	res, err := db.Exec(`
+UPDATE servers SET circuit_state = 'closed', circuit_failures = 0, circuit_opened_at = NULL
+WHERE id = $1 AND circuit_state = 'open'`, id)
+	if err != nil {
+		return err
+	}
+	resetCircuit(id)
+	if n, _ := res.RowsAffected(); n > 0 {
+		log.WithField("server", id).Info("Circuit closed for server")
+	}
+	return nil
+}
+
+// OpenCircuit opens the circuit of the server, holding its requests until a probe succeeds
+func OpenCircuit(db sqlx.Execer, id ServerID) error {
+	res, err := db.Exec(`
+UPDATE servers SET circuit_state = 'open', circuit_opened_at = current_timestamp
+WHERE id = $1 AND circuit_state = 'closed'`, id)
+	if err != nil {
+		return err
+	}
+	resetCircuit(id)
+	if n, _ := res.RowsAffected(); n > 0 {
+		log.WithField("server", id).Warn("Circuit opened for server")
+	}
+	return nil
+}
+
+// DueCircuitProbes returns the servers with an open circuit whose next probe is due and
+// marks them probed. Each instance keeps its own probe schedule
+func DueCircuitProbes(db sqlx.Queryer) ([]Server, error) {
+	rows, err := db.Queryx(`SELECT * FROM servers WHERE circuit_state = 'open'`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	now := time.Now()
+	servers := []Server{}
+	circuits.Lock()
+	defer circuits.Unlock()
+	for rows.Next() {
+		srv := Server{}
+		if err := rows.StructScan(&srv.s); err != nil {
+			return nil, err
+		}
+		last := circuits.probedAt[srv.ID()]
+		if srv.s.CircuitOpenedAt != nil && srv.s.CircuitOpenedAt.After(last) {
+			last = *srv.s.CircuitOpenedAt // reopened since the last probe
+		}
+		if last.Add(time.Duration(srv.s.CircuitProbeInterval) * time.Second).After(now) {
+			continue
+		}
+		circuits.probedAt[srv.ID()] = now
+		servers = append(servers, srv)
+	}
+	return servers, rows.Err()
+}
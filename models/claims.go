@@ -0,0 +1,117 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// ClaimedRequest is a request claimed for sending
+type ClaimedRequest struct {
+	ID          RequestID `db:"id"`
+	Destination ServerID  `db:"destination"`
+	IsCopy      bool      `db:"is_copy"` // copies to cc_urls do not use up the limits of the destination
+}
+
+// claimableSQL matches ready requests, failed requests due for a retry and requests whose lease has expired
+const claimableSQL = `
+	(status = 'ready'
+		OR (status = 'failed' AND next_attempt_at <= current_timestamp)
+		OR (status = 'inprogress' AND lease_expires_at < current_timestamp))`
+
+// effectivePrioritySQL raises the priority of a request by one for every $5 seconds it has
+// waited so that low priority requests are not starved by a steady stream of higher ones.
+// An interval of 0 disables aging
+const effectivePrioritySQL = `
+	r.priority + COALESCE(
+		floor(EXTRACT(EPOCH FROM current_timestamp - r.created) / NULLIF($5, 0)), 0)`
+
+// claimRequestsSQL moves up to $1 sendable requests to inprogress with a lease of $2 seconds.
+// Requests are only claimable while their destination is not suspended, has a closed circuit,
+// is within its submission period and has room left under its max_in_flight and rate limit.
+// The rate budgets are given as the server ids $3 and budgets $4. They come from the token
+// buckets of the RateLimiter, which lives in the process, so with several integrator instances
+// each one enforces the rate limit on its own and a destination may receive up to that many
+// times its rate_limit. max_in_flight is counted in the database and holds across instances.
+// Requests are claimed by effectivePrioritySQL, oldest first within a priority, and SKIP LOCKED
+// lets several dispatchers claim concurrently without waiting on each other. Copies to cc_urls
+// go to other hosts, so they are neither held by the circuit of the destination nor counted
+// against its max_in_flight and rate budgets
+const claimRequestsSQL = `
+UPDATE requests SET
+	status = 'inprogress',
+	lease_expires_at = current_timestamp + $2 * INTERVAL '1 second',
+	updated = current_timestamp
+WHERE id IN (
+	SELECT id FROM requests
+	WHERE id IN (
+		SELECT id FROM (
+			SELECT
+				r.id, r.created, ` + effectivePrioritySQL + ` AS priority,
+				r.parent_id,
+				row_number() OVER (
+					PARTITION BY r.destination, r.parent_id IS NOT NULL ORDER BY ` + effectivePrioritySQL + ` DESC, r.created) AS rn,
+				LEAST(
+					CASE WHEN s.max_in_flight > 0 THEN s.max_in_flight - COALESCE(f.n, 0) END,
+					b.budget) AS capacity
+			FROM requests r
+			INNER JOIN servers s ON s.id = r.destination
+			LEFT JOIN (
+				SELECT destination, count(*) AS n FROM requests
+				WHERE status = 'inprogress' AND lease_expires_at >= current_timestamp AND parent_id IS NULL
+				GROUP BY destination) f ON f.destination = r.destination
+			LEFT JOIN unnest(CAST($3 AS INTEGER[]), CAST($4 AS INTEGER[])) AS b(destination, budget)
+				ON b.destination = r.destination
+			WHERE ` + claimableSQL + `
+				AND NOT s.suspended
+				AND (s.circuit_state = 'closed' OR r.parent_id IS NOT NULL)
+				AND COALESCE(in_submission_period(r.destination), FALSE)) ranked
+		WHERE capacity IS NULL OR rn <= capacity OR parent_id IS NOT NULL
+		ORDER BY priority DESC, created
+		LIMIT $1)
+	AND ` + claimableSQL + `
+	FOR UPDATE SKIP LOCKED)
+RETURNING id, destination, parent_id IS NOT NULL AS is_copy`
+
+// ClaimRequests claims up to limit requests for sending. The claim lasts for lease, after
+// which the requests may be claimed again if they were not finished. budgets limits how many
+// requests may be claimed for each rate limited destination and every aging interval a request
+// waits raises its priority by one
+func ClaimRequests(
+	db sqlx.Queryer, limit int, lease time.Duration, budgets map[ServerID]int, aging time.Duration,
+) ([]ClaimedRequest, error) {
+	servers := make([]int64, 0, len(budgets))
+	allowed := make([]int64, 0, len(budgets))
+	for id, n := range budgets {
+		servers = append(servers, int64(id))
+		allowed = append(allowed, int64(n))
+	}
+	claimed := []ClaimedRequest{}
+	err := sqlx.Select(db, &claimed, claimRequestsSQL,
+		limit, int(lease/time.Second), pq.Array(servers), pq.Array(allowed), int(aging/time.Second))
+	return claimed, err
+}
+
+// ReleaseRequest returns a claimed request to the queue without counting an attempt
+func ReleaseRequest(db sqlx.Execer, id RequestID) error {
+	_, err := db.Exec(`
+UPDATE requests SET status = 'ready', lease_expires_at = NULL, updated = current_timestamp
+WHERE id = $1 AND status = 'inprogress'`, id)
+	return err
+}
+
+// RecoverExpiredLeases returns requests left inprogress by a dispatcher that stopped without
+// finishing them to the queue. Requests claimed before leases were recorded are recovered once
+// they have not been updated for lease. It returns the number of recovered requests
+func RecoverExpiredLeases(db sqlx.Execer, lease time.Duration) (int64, error) {
+	res, err := db.Exec(`
+UPDATE requests SET status = 'ready', lease_expires_at = NULL, updated = current_timestamp
+WHERE status = 'inprogress'
+	AND COALESCE(lease_expires_at, updated + $1 * INTERVAL '1 second') < current_timestamp`,
+		int(lease/time.Second))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
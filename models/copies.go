@@ -0,0 +1,39 @@
+package models
+
+import (
+	"github.com/gcinnovate/integrator/utils"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// insertCopiesSQL queues a copy of the request $1 for each of the cc_urls $2 with the uids $3.
+// Copies are requests of their own, sent and retried independently of the request they copy
+const insertCopiesSQL = `
+INSERT INTO requests (parent_id, cc_url, uid, source, destination, batchid, ctype, body, body_is_query_param,
+	period, week, month, year, raw_msg, msisdn, facility, district, report_type, object_type, extras,
+	url_suffix, priority, created, updated)
+SELECT
+	r.id, cc.url, cc.uid, r.source, r.destination, r.batchid, r.ctype, r.body, r.body_is_query_param,
+	r.period, r.week, r.month, r.year, r.raw_msg, r.msisdn, r.facility, r.district, r.report_type,
+	r.object_type, r.extras, r.url_suffix, r.priority, now(), now()
+FROM requests r, unnest(CAST($2 AS TEXT[]), CAST($3 AS TEXT[])) AS cc(url, uid)
+WHERE r.id = $1`
+
+// insertCopies queues the copies of the request with the given id to the cc_urls of its
+// destination when the destination allows copies
+func insertCopies(db sqlx.Execer, id RequestID, destination int) error {
+	server, ok := LookupServer(destination)
+	if !ok {
+		return nil
+	}
+	urls := server.CopyURLs()
+	if len(urls) == 0 {
+		return nil
+	}
+	uids := make([]string, len(urls))
+	for i := range urls {
+		uids[i] = utils.GetUID()
+	}
+	_, err := db.Exec(insertCopiesSQL, id, pq.Array(urls), pq.Array(uids))
+	return err
+}
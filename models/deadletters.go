@@ -0,0 +1,210 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// ErrNoReplaySelection is returned when a replay selects neither uids nor a group of dead letters
+var ErrNoReplaySelection = errors.New("select the dead letters to replay by uids, destination, statuscode or status")
+
+// deadLetterStatuses are the statuses a dead letter can have
+var deadLetterStatuses = []RequestStatus{
+	RequestStatusExpired, RequestStatusCanceled, RequestStatusError, RequestStatusFailed}
+
+// DeadLetterFilter selects dead letters. Zero values match everything
+type DeadLetterFilter struct {
+	Destination ServerID
+	StatusCode  *string // the empty string selects dead letters without a status code
+	Status      RequestStatus
+}
+
+// DeadLetterGroup summarises the dead letters of a destination with the same status code
+type DeadLetterGroup struct {
+	Destination     ServerID       `db:"destination" json:"destination"`
+	DestinationName string         `db:"destination_name" json:"destinationName"`
+	StatusCode      string         `db:"statuscode" json:"statuscode"`
+	Count           int            `db:"count" json:"count"`
+	Statuses        pq.StringArray `db:"statuses" json:"statuses"`
+	LastError       string         `db:"last_error" json:"lastError"`
+	LastResponse    string         `db:"last_response" json:"lastResponse"`
+	FirstUpdated    time.Time      `db:"first_updated" json:"firstUpdated"`
+	LastUpdated     time.Time      `db:"last_updated" json:"lastUpdated"`
+}
+
+// DeadLetter is a request that will not be sent again unless it is replayed
+type DeadLetter struct {
+	UID             string        `db:"uid" json:"uid"`
+	Source          ServerID      `db:"source" json:"source"`
+	Destination     ServerID      `db:"destination" json:"destination"`
+	DestinationName string        `db:"destination_name" json:"destinationName"`
+	BatchID         string        `db:"batchid" json:"batchId"`
+	Status          RequestStatus `db:"status" json:"status"`
+	StatusCode      string        `db:"statuscode" json:"statuscode"`
+	Errors          string        `db:"errors" json:"errors"`
+	Response        string        `db:"response" json:"response"`
+	Retries         int           `db:"retries" json:"retries"`
+	ObjectType      string        `db:"object_type" json:"objectType"`
+	ContentType     string        `db:"ctype" json:"contentType"`
+	Created         time.Time     `db:"created" json:"created"`
+	Updated         time.Time     `db:"updated" json:"updated"`
+}
+
+// ReplayEdit replaces the body of a dead letter before it is replayed
+type ReplayEdit struct {
+	UID  string          `json:"uid"`
+	Body json.RawMessage `json:"body"`
+}
+
+// ReplayResult reports the dead letters that were put back into the queue
+type ReplayResult struct {
+	Replayed int      `json:"replayed"`
+	UIDs     []string `json:"uids"`
+}
+
+// ValidDeadLetterStatus returns whether status is one a dead letter can have
+func ValidDeadLetterStatus(status RequestStatus) bool {
+	for _, s := range deadLetterStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// where returns the conditions of the filter on the dead_letters view and their arguments
+func (f DeadLetterFilter) where(args []interface{}) (string, []interface{}) {
+	conds := []string{"TRUE"}
+	if f.Destination != 0 {
+		args = append(args, f.Destination)
+		conds = append(conds, fmt.Sprintf("destination = $%d", len(args)))
+	}
+	if f.StatusCode != nil {
+		args = append(args, *f.StatusCode)
+		conds = append(conds, fmt.Sprintf("statuscode = $%d", len(args)))
+	}
+	if f.Status != "" {
+		args = append(args, f.Status)
+		conds = append(conds, fmt.Sprintf("status = $%d", len(args)))
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+// GetDeadLetterGroups returns the dead letters grouped by destination and status code,
+// largest groups first
+func GetDeadLetterGroups(db sqlx.Queryer, filter DeadLetterFilter) ([]DeadLetterGroup, error) {
+	where, args := filter.where(nil)
+	groups := []DeadLetterGroup{}
+	err := sqlx.Select(db, &groups, `
+SELECT
+	COALESCE(destination, 0) AS destination, destination_name, statuscode, COUNT(*) AS count,
+	array_agg(DISTINCT status) AS statuses,
+	(array_agg(errors ORDER BY updated DESC))[1] AS last_error,
+	(array_agg(response ORDER BY updated DESC))[1] AS last_response,
+	MIN(updated) AS first_updated, MAX(updated) AS last_updated
+FROM dead_letters
+WHERE `+where+`
+GROUP BY destination, destination_name, statuscode
+ORDER BY count DESC, destination_name, statuscode`, args...)
+	return groups, err
+}
+
+// GetDeadLetters returns a page of the dead letters matching filter, most recent first,
+// together with the number of matching dead letters
+func GetDeadLetters(db sqlx.Queryer, filter DeadLetterFilter, page, pageSize int) ([]DeadLetter, int, error) {
+	where, args := filter.where(nil)
+	var total int
+	if err := sqlx.Get(db, &total, "SELECT COUNT(*) FROM dead_letters WHERE "+where, args...); err != nil {
+		return nil, 0, err
+	}
+	args = append(args, pageSize, (page-1)*pageSize)
+	letters := []DeadLetter{}
+	err := sqlx.Select(db, &letters, fmt.Sprintf(`
+SELECT
+	uid, COALESCE(source, 0) AS source, COALESCE(destination, 0) AS destination, destination_name,
+	batchid, status, statuscode, errors, response, retries, object_type, ctype, created, updated
+FROM dead_letters
+WHERE %s
+ORDER BY updated DESC
+LIMIT $%d OFFSET $%d`, where, len(args)-1, len(args)), args...)
+	return letters, total, err
+}
+
+// ReplayDeadLetters puts the dead letters selected by uids or filter back into the queue
+// with their retries reset. Edited bodies are validated against the object type of their
+// request before anything is replayed, and the edited requests are replayed as well
+func ReplayDeadLetters(db *sqlx.DB, filter DeadLetterFilter, uids []string, edits []ReplayEdit) (ReplayResult, error) {
+	result := ReplayResult{UIDs: []string{}}
+	if len(uids) == 0 && len(edits) == 0 && filter == (DeadLetterFilter{}) {
+		return result, ErrNoReplaySelection
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return result, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var errs fieldErrors
+	for i, edit := range edits {
+		field := fmt.Sprintf("edits[%d]", i)
+		var letter DeadLetter
+		err := tx.Get(&letter, "SELECT uid, object_type, ctype FROM dead_letters WHERE uid = $1", edit.UID)
+		if errors.Is(err, sql.ErrNoRows) {
+			errs.add(field+".uid", "is not a dead letter")
+			continue
+		}
+		if err != nil {
+			return result, err
+		}
+		body, err := storedBody(edit.Body, letter.ContentType)
+		if err != nil {
+			errs.add(field+".body", err.Error())
+			continue
+		}
+		var validationErr *ValidationError
+		if err := ValidateBody(letter.ObjectType, letter.ContentType, []byte(body)); errors.As(err, &validationErr) {
+			for _, fe := range validationErr.Errors {
+				errs.add(strings.TrimSuffix(field+".body."+fe.Field, "."), fe.Message)
+			}
+			continue
+		}
+		if _, err := tx.Exec("UPDATE requests SET body = $2 WHERE uid = $1", edit.UID, body); err != nil {
+			return result, err
+		}
+		uids = append(uids, edit.UID)
+	}
+	if len(errs) > 0 {
+		return result, &ValidationError{Errors: errs}
+	}
+
+	var selection string
+	args := []interface{}{}
+	if len(uids) > 0 {
+		where, whereArgs := filter.where([]interface{}{pq.Array(uids)})
+		selection, args = "uid = ANY($1) AND "+where, whereArgs
+	} else {
+		selection, args = filter.where(args)
+	}
+	err = tx.Select(&result.UIDs, `
+UPDATE requests SET
+	status = 'ready', statuscode = '', errors = '', response = '', retries = 0, suspended = 0,
+	next_attempt_at = NULL, lease_expires_at = NULL, updated = current_timestamp
+WHERE id IN (SELECT id FROM dead_letters WHERE `+selection+`)
+RETURNING uid`, args...)
+	if err != nil {
+		return result, err
+	}
+	if err := tx.Commit(); err != nil {
+		return result, err
+	}
+	result.Replayed = len(result.UIDs)
+	return result, nil
+}
@@ -69,10 +69,10 @@ type ImportOptions struct {
 
 //ImportCount the import count in response
 type ImportCount struct {
-	Imported int
-	Updated  int
-	Ignored  int
-	Deleted  int
+	Imported int `json:"imported"`
+	Updated  int `json:"updated"`
+	Ignored  int `json:"ignored"`
+	Deleted  int `json:"deleted"`
 }
 
 type ConflictObject struct {
@@ -91,12 +91,13 @@ type Response struct {
 	Description     string
 	Conflicts       []ConflictObject `json:"conflicts,omitempty"`
 	DataSetComplete string           `json:"dataSetComplete,omitempty"`
+	ImportSummaries []Response       `json:"importSummaries,omitempty"` // of each object for ImportSummaries responses
 }
 
 // ImportSummary for Aggregate and Async Requests
 type ImportSummary struct {
 	HTTPStatus     string `json:"httpStatus"`
-	HTTPStatusCode string `json:"httpStatusCode"`
+	HTTPStatusCode int    `json:"httpStatusCode"`
 	Response       Response
 	Status         string
 	Message        string
@@ -0,0 +1,200 @@
+package models
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// OutboundBody is a request body as it is sent to the destination
+type OutboundBody struct {
+	Body        []byte
+	ContentType string
+	Accept      string
+}
+
+// bodyEncoding is how bodies of a media type are sent
+type bodyEncoding struct {
+	transform bool   // whether the JSON transforms of the destination are applied
+	accept    string // the media type asked for in responses
+}
+
+// bodyEncodings are the encodings of each supported media type. Bodies are passed through byte
+// for byte unless they are JSON with a transform configured, other media types are passed through
+var bodyEncodings = map[string]bodyEncoding{
+	"application/json":                  {transform: true, accept: "application/json"},
+	"application/xml":                   {accept: "application/xml"},
+	"text/xml":                          {accept: "text/xml"},
+	"application/adx+xml":               {accept: "application/xml"},
+	"text/csv":                          {accept: "*/*"},
+	"application/csv":                   {accept: "*/*"},
+	"application/x-www-form-urlencoded": {accept: "*/*"},
+}
+
+// EncodeBody picks the encoding for the content type of a stored body and returns the body to
+// send to the server with its headers. Bodies without a content type are sent as JSON, and JSON
+// bodies go through the JSON transform of the server for objectType. DHIS2 servers are asked for
+// JSON whatever was sent so that their import summaries can be read
+func (s *Server) EncodeBody(body, contentType, objectType string) (OutboundBody, error) {
+	if strings.TrimSpace(contentType) == "" {
+		contentType = "application/json"
+	}
+	encoding, ok := bodyEncodings[MediaType(contentType)]
+	if !ok {
+		encoding = bodyEncoding{accept: "*/*"}
+	}
+	out := OutboundBody{Body: []byte(body), ContentType: contentType, Accept: encoding.accept}
+	if strings.EqualFold(s.s.SystemType, "DHIS2") {
+		out.Accept = "application/json"
+	}
+	if encoding.transform {
+		if transform, ok := s.s.JSONTransforms.forObjectType(objectType); ok {
+			encoded, err := transform.Apply(out.Body)
+			if err != nil {
+				return OutboundBody{}, fmt.Errorf("failed to transform %s body: %w", objectType, err)
+			}
+			out.Body = encoded
+		}
+	}
+	return out, nil
+}
+
+// JSONTransform rewrites the JSON bodies of an object type before they are sent. The body is
+// first taken from its Unwrap field, then the fields of the body object, or of each object in
+// a body array, are removed, renamed and set in that order, and last the result is wrapped in
+// an object under Wrap
+type JSONTransform struct {
+	Unwrap string                     `json:"unwrap,omitempty"`
+	Remove []string                   `json:"remove,omitempty"`
+	Rename map[string]string          `json:"rename,omitempty"`
+	Set    map[string]json.RawMessage `json:"set,omitempty"`
+	Wrap   string                     `json:"wrap,omitempty"`
+}
+
+// Apply returns body transformed
+func (t JSONTransform) Apply(body []byte) ([]byte, error) {
+	body = bytes.TrimSpace(body)
+	if t.Unwrap != "" {
+		var wrapper map[string]json.RawMessage
+		if err := json.Unmarshal(body, &wrapper); err != nil {
+			return nil, fmt.Errorf("cannot unwrap %q: %v", t.Unwrap, err)
+		}
+		inner, ok := wrapper[t.Unwrap]
+		if !ok {
+			return nil, fmt.Errorf("body has no %q field to unwrap", t.Unwrap)
+		}
+		body = bytes.TrimSpace(inner)
+	}
+	if len(t.Remove) > 0 || len(t.Rename) > 0 || len(t.Set) > 0 {
+		var err error
+		if bytes.HasPrefix(body, []byte("[")) {
+			var items []json.RawMessage
+			if err := json.Unmarshal(body, &items); err != nil {
+				return nil, err
+			}
+			for i, item := range items {
+				if item = bytes.TrimSpace(item); bytes.HasPrefix(item, []byte("{")) {
+					if items[i], err = t.applyToObject(item); err != nil {
+						return nil, err
+					}
+				}
+			}
+			body, err = json.Marshal(items)
+		} else {
+			body, err = t.applyToObject(body)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if t.Wrap != "" {
+		return json.Marshal(map[string]json.RawMessage{t.Wrap: body})
+	}
+	return body, nil
+}
+
+// applyToObject removes, renames and sets the fields of a JSON object
+func (t JSONTransform) applyToObject(body []byte) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, err
+	}
+	for _, name := range t.Remove {
+		delete(fields, name)
+	}
+	for from, to := range t.Rename {
+		if value, ok := fields[from]; ok {
+			delete(fields, from)
+			fields[to] = value
+		}
+	}
+	for name, value := range t.Set {
+		fields[name] = value
+	}
+	return json.Marshal(fields)
+}
+
+// JSONTransforms are the JSON transforms of a server keyed by object type. The transform
+// keyed "*" applies to object types without one of their own
+type JSONTransforms map[string]JSONTransform
+
+// forObjectType returns the transform of objectType
+func (t JSONTransforms) forObjectType(objectType string) (JSONTransform, bool) {
+	if transform, ok := t[objectType]; ok {
+		return transform, true
+	}
+	transform, ok := t["*"]
+	return transform, ok
+}
+
+// validJSONTransforms adds an error for each transform that does nothing or renames to or from
+// an empty name
+func (fe *fieldErrors) validJSONTransforms(field string, transforms JSONTransforms) {
+	for objectType, t := range transforms {
+		name := fmt.Sprintf("%s.%s", field, objectType)
+		if t.Unwrap == "" && t.Wrap == "" && len(t.Remove) == 0 && len(t.Rename) == 0 && len(t.Set) == 0 {
+			fe.add(name, "must unwrap, remove, rename, set or wrap")
+		}
+		for from, to := range t.Rename {
+			if strings.TrimSpace(from) == "" || strings.TrimSpace(to) == "" {
+				fe.add(name+".rename", "must not contain empty field names")
+			}
+		}
+	}
+}
+
+// Value implements the driver.Valuer interface for the json_transforms JSONB column
+func (t JSONTransforms) Value() (driver.Value, error) {
+	if t == nil {
+		return "{}", nil
+	}
+	b, err := json.Marshal(t)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Scan implements the sql.Scanner interface for the json_transforms JSONB column
+func (t *JSONTransforms) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case nil:
+		*t = JSONTransforms{}
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return fmt.Errorf("cannot scan %T into JSONTransforms", src)
+	}
+	transforms := JSONTransforms{}
+	if err := json.Unmarshal(data, &transforms); err != nil {
+		return err
+	}
+	*t = transforms
+	return nil
+}
@@ -0,0 +1,155 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	log "github.com/sirupsen/logrus"
+)
+
+// RequestStatusChannel is the Postgres channel on which request status changes are published
+const RequestStatusChannel = "request_status"
+
+// ServersChangedChannel is the Postgres channel notified when the servers table changes
+const ServersChangedChannel = "servers_changed"
+
+// RequestEvent is a status transition of a request as published by the database
+type RequestEvent struct {
+	UID            string        `json:"uid"`
+	Source         int           `json:"source"`
+	Destination    int           `json:"destination"`
+	BatchID        string        `json:"batchid"`
+	Status         RequestStatus `json:"status"`
+	PreviousStatus RequestStatus `json:"previousStatus"`
+	StatusCode     string        `json:"statuscode"`
+	Errors         string        `json:"errors"`
+	Retries        int           `json:"retries"`
+	Updated        string        `json:"updated"`
+}
+
+// subscriberBuffer is the number of events a slow subscriber may lag behind before losing events
+const subscriberBuffer = 64
+
+var requestEvents = struct {
+	sync.Mutex
+	subscribers map[chan RequestEvent]struct{}
+	closed      bool
+}{subscribers: make(map[chan RequestEvent]struct{})}
+
+// SubscribeRequestEvents returns a channel receiving request status changes and
+// the function to call when the subscriber is done. The channel is closed when the
+// listener stops
+func SubscribeRequestEvents() (<-chan RequestEvent, func()) {
+	ch := make(chan RequestEvent, subscriberBuffer)
+	requestEvents.Lock()
+	if requestEvents.closed {
+		close(ch)
+	} else {
+		requestEvents.subscribers[ch] = struct{}{}
+	}
+	requestEvents.Unlock()
+
+	unsubscribe := func() {
+		requestEvents.Lock()
+		if _, ok := requestEvents.subscribers[ch]; ok {
+			delete(requestEvents.subscribers, ch)
+			close(ch)
+		}
+		requestEvents.Unlock()
+	}
+	return ch, unsubscribe
+}
+
+// requestsReady is signalled when requests become ready to send. It holds at most one
+// pending signal so that a burst of inserts wakes the producer only once
+var requestsReady = make(chan struct{}, 1)
+
+// RequestsReady returns the channel signalled when a request is queued or made ready again
+func RequestsReady() <-chan struct{} { return requestsReady }
+
+// publishRequestEvent hands the event to every subscriber without blocking on slow ones
+func publishRequestEvent(ev RequestEvent) {
+	if ev.Status == RequestStatusReady {
+		select {
+		case requestsReady <- struct{}{}:
+		default:
+		}
+	}
+	requestEvents.Lock()
+	defer requestEvents.Unlock()
+	for ch := range requestEvents.subscribers {
+		select {
+		case ch <- ev:
+		default:
+			log.WithField("uid", ev.UID).Warn("Dropping request event for slow subscriber")
+		}
+	}
+}
+
+// closeRequestEvents closes the channels of all subscribers, ending their event streams
+func closeRequestEvents() {
+	requestEvents.Lock()
+	defer requestEvents.Unlock()
+	requestEvents.closed = true
+	for ch := range requestEvents.subscribers {
+		delete(requestEvents.subscribers, ch)
+		close(ch)
+	}
+}
+
+// ListenForEvents listens for request status notifications on the database and publishes
+// them to the subscribers until ctx is canceled, then it closes the channels of the subscribers.
+// Servers are reloaded whenever they are changed, including by other processes sharing the
+// database. It blocks, so it is meant to run in its own goroutine
+func ListenForEvents(ctx context.Context, dbURI string) {
+	defer closeRequestEvents()
+	reportProblem := func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.WithError(err).Error("Request events listener problem")
+		}
+	}
+	listener := pq.NewListener(dbURI, 10*time.Second, time.Minute, reportProblem)
+	defer listener.Close()
+	for _, channel := range []string{RequestStatusChannel, ServersChangedChannel} {
+		if err := listener.Listen(channel); err != nil {
+			log.WithError(err).WithField("channel", channel).Error("Failed to listen for events")
+			<-ctx.Done() // subscribers get no events but keep their streams until shutdown
+			return
+		}
+	}
+	log.WithField("channels", []string{RequestStatusChannel, ServersChangedChannel}).Info("Listening for events")
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case n := <-listener.Notify:
+			if n == nil { // the connection was re-established, notifications may have been lost
+				reloadServers()
+				continue
+			}
+			if n.Channel == ServersChangedChannel {
+				reloadServers()
+				continue
+			}
+			ev := RequestEvent{}
+			if err := json.Unmarshal([]byte(n.Extra), &ev); err != nil {
+				log.WithError(err).Error("Failed to decode request event")
+				continue
+			}
+			publishRequestEvent(ev)
+		case <-time.After(90 * time.Second):
+			go func() { _ = listener.Ping() }()
+		}
+	}
+}
+
+// reloadServers refreshes the loaded servers after a servers_changed notification
+func reloadServers() {
+	if err := LoadServers(); err != nil {
+		log.WithError(err).Error("Failed to reload servers")
+	}
+}
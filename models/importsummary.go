@@ -0,0 +1,162 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gcinnovate/integrator/pages"
+)
+
+// import statuses recorded as the statuscode of a request that was imported
+const (
+	ImportStatusSuccess = "SUCCESS"
+	ImportStatusWarning = "WARNING" // imported with conflicts, warnings or ignored objects
+	ImportStatusError   = "ERROR"
+	ImportStatusIgnored = "IGNORED" // nothing was imported and the conflicts say why
+)
+
+// ImportOutcome is the outcome of a request decided from the import summary in its response
+type ImportOutcome struct {
+	Status     RequestStatus
+	StatusCode string
+	Errors     string
+	Count      ImportCount
+}
+
+// InterpretImportSummary decides the outcome of a request from the DHIS2 import summary in
+// body. It understands aggregate ImportSummary and ImportSummaries responses, with or without
+// the web message wrapping of newer servers, and tracker import reports. It returns false when
+// body is not an import summary
+func InterpretImportSummary(body []byte) (ImportOutcome, bool) {
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(body, &keys); err != nil {
+		return ImportOutcome{}, false
+	}
+	if _, ok := keys["validationReport"]; ok {
+		report := pages.ImportSummary{}
+		if err := decodeSummary(body, &report); err != nil {
+			return ImportOutcome{}, false
+		}
+		return trackerOutcome(report), true
+	}
+	if _, ok := keys["responseType"]; ok {
+		summary := Response{}
+		if err := decodeSummary(body, &summary); err != nil {
+			return ImportOutcome{}, false
+		}
+		switch summary.ResponseType {
+		case "ImportSummary", "ImportSummaries":
+			return summaryOutcome(summary), true
+		}
+		return ImportOutcome{}, false
+	}
+	if response, ok := keys["response"]; ok && keys["httpStatus"] != nil {
+		// a web message, newer servers wrap import summaries in one
+		return InterpretImportSummary(response)
+	}
+	return ImportOutcome{}, false
+}
+
+// decodeSummary decodes an import summary, skipping fields whose type differs between versions
+func decodeSummary(body []byte, v interface{}) error {
+	var typeErr *json.UnmarshalTypeError
+	if err := json.Unmarshal(body, v); err != nil && !errors.As(err, &typeErr) {
+		return err
+	}
+	return nil
+}
+
+// summaryOutcome decides the outcome of an aggregate or old tracker import summary
+func summaryOutcome(summary Response) ImportOutcome {
+	count := summary.ImportCount
+	messages := conflictMessages(summary.Conflicts)
+	for _, s := range summary.ImportSummaries {
+		if summary.ResponseType == "ImportSummaries" {
+			count.Imported += s.ImportCount.Imported
+			count.Updated += s.ImportCount.Updated
+			count.Ignored += s.ImportCount.Ignored
+			count.Deleted += s.ImportCount.Deleted
+		}
+		messages = append(messages, conflictMessages(s.Conflicts)...)
+		if strings.EqualFold(string(s.Status), ImportStatusError) && s.Description != "" {
+			messages = append(messages, s.Description)
+		}
+	}
+	if strings.EqualFold(string(summary.Status), ImportStatusError) && len(messages) == 0 && summary.Description != "" {
+		messages = append(messages, summary.Description)
+	}
+	return importOutcome(string(summary.Status), count, messages)
+}
+
+// trackerOutcome decides the outcome of a tracker import report
+func trackerOutcome(report pages.ImportSummary) ImportOutcome {
+	count := ImportCount{
+		Imported: report.Stats.Created,
+		Updated:  report.Stats.Updated,
+		Ignored:  report.Stats.Ignored,
+		Deleted:  report.Stats.Deleted,
+	}
+	var messages []string
+	for _, r := range report.ValidationReport.ErrorReports {
+		messages = append(messages, reportMessage(r))
+	}
+	for _, r := range report.ValidationReport.WarningReports {
+		messages = append(messages, reportMessage(r))
+	}
+	status := strings.ToUpper(report.Status)
+	if status == "OK" {
+		status = ImportStatusSuccess
+	}
+	return importOutcome(status, count, messages)
+}
+
+// importOutcome maps the status, counts and conflict messages of an import to the outcome of
+// its request. Errors fail the request, as do imports where everything was ignored because of
+// conflicts. Imports with warnings, conflicts or ignored objects complete with a WARNING
+func importOutcome(status string, count ImportCount, messages []string) ImportOutcome {
+	outcome := ImportOutcome{Count: count, Errors: strings.Join(messages, "; ")}
+	changed := count.Imported + count.Updated + count.Deleted
+	switch {
+	case strings.EqualFold(status, ImportStatusError):
+		outcome.Status, outcome.StatusCode = RequestStatusFailed, ImportStatusError
+		if outcome.Errors == "" {
+			outcome.Errors = "Import failed"
+		}
+	case count.Ignored > 0 && changed == 0 && len(messages) > 0:
+		outcome.Status, outcome.StatusCode = RequestStatusFailed, ImportStatusIgnored
+	case strings.EqualFold(status, ImportStatusWarning) || len(messages) > 0 || count.Ignored > 0:
+		outcome.Status, outcome.StatusCode = RequestStatusCompleted, ImportStatusWarning
+		if outcome.Errors == "" && count.Ignored > 0 {
+			outcome.Errors = fmt.Sprintf("%d ignored", count.Ignored)
+		}
+	default:
+		outcome.Status, outcome.StatusCode = RequestStatusCompleted, ImportStatusSuccess
+	}
+	return outcome
+}
+
+// conflictMessages describes the conflicts of an import summary
+func conflictMessages(conflicts []ConflictObject) []string {
+	messages := make([]string, 0, len(conflicts))
+	for _, c := range conflicts {
+		switch {
+		case c.Object != "" && c.Value != "":
+			messages = append(messages, c.Object+": "+c.Value)
+		case c.Value != "":
+			messages = append(messages, c.Value)
+		case c.Object != "":
+			messages = append(messages, c.Object)
+		}
+	}
+	return messages
+}
+
+// reportMessage describes an error or warning report of a tracker import
+func reportMessage(r pages.Report) string {
+	if r.TrackerType != "" && r.UID != "" {
+		return fmt.Sprintf("%s %s: %s", r.TrackerType, r.UID, r.Message)
+	}
+	return r.Message
+}
@@ -0,0 +1,167 @@
+package models
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// oauth2TokenRefreshMargin is how long before it expires a cached access token is replaced
+const oauth2TokenRefreshMargin = time.Minute
+
+// oauth2DefaultTokenTTL is how long a token is used when the endpoint did not say when it expires
+const oauth2DefaultTokenTTL = 10 * time.Minute
+
+// oauth2Token is an access token issued to a server by its token endpoint
+type oauth2Token struct {
+	AccessToken string
+	TokenType   string
+	Expiry      time.Time
+}
+
+// valid reports whether the token can still be used at now
+func (t *oauth2Token) valid(now time.Time) bool {
+	return t.AccessToken != "" && now.Add(oauth2TokenRefreshMargin).Before(t.Expiry)
+}
+
+// oauth2Auth implements the OAuth2 client credentials grant. The client id and secret are the
+// username and password of the server, the token endpoint is the tokenURL auth param and the
+// optional scope the scope auth param. Tokens are cached per server until shortly before
+// they expire or the server rejects them
+type oauth2Auth struct {
+	mu     sync.Mutex
+	tokens map[ServerID]*oauth2CachedToken
+}
+
+// oauth2CachedToken is the token of a server, its lock is held while the token is requested
+// so concurrent sends share a single token request. settings is a hash of the token settings
+// and credentials the token was issued for
+type oauth2CachedToken struct {
+	mu       sync.Mutex
+	settings [sha256.Size]byte
+	token    *oauth2Token
+}
+
+func newOAuth2Auth() *oauth2Auth {
+	return &oauth2Auth{tokens: map[ServerID]*oauth2CachedToken{}}
+}
+
+func (a *oauth2Auth) Authenticate(req *http.Request, server *Server) error {
+	token, err := a.token(req.Context(), server)
+	if err != nil {
+		return err
+	}
+	tokenType := token.TokenType
+	if tokenType == "" || strings.EqualFold(tokenType, "bearer") {
+		tokenType = "Bearer"
+	}
+	req.Header.Set("Authorization", tokenType+" "+token.AccessToken)
+	return nil
+}
+
+func (a *oauth2Auth) Check(server *Server) []FieldError {
+	var errs fieldErrors
+	if tokenURL := server.authParam("tokenURL", ""); tokenURL == "" {
+		errs.add("authParams.tokenURL", "is required when auth_method is "+AuthOAuth2)
+	} else {
+		errs.validURL("authParams.tokenURL", tokenURL)
+	}
+	errs.required("username", server.s.Username)
+	errs.required("password", server.s.Password)
+	return errs
+}
+
+// Invalidate drops the cached token of the server so that the next send requests a new one
+func (a *oauth2Auth) Invalidate(server *Server) {
+	a.mu.Lock()
+	delete(a.tokens, server.s.ID)
+	a.mu.Unlock()
+}
+
+// oauth2Settings hashes what the token of a server depends on, so that the secret is not kept
+// in the cache
+func oauth2Settings(server *Server) [sha256.Size]byte {
+	return sha256.Sum256([]byte(strings.Join([]string{server.authParam("tokenURL", ""),
+		server.authParam("scope", ""), server.s.Username, server.s.Password}, "\x00")))
+}
+
+// token returns the cached token of the server, requesting a new one when it is about to expire.
+// Changing the credentials or token settings of the server replaces the cached token
+func (a *oauth2Auth) token(ctx context.Context, server *Server) (*oauth2Token, error) {
+	settings := oauth2Settings(server)
+	a.mu.Lock()
+	cached, ok := a.tokens[server.s.ID]
+	if !ok || cached.settings != settings {
+		cached = &oauth2CachedToken{settings: settings}
+		a.tokens[server.s.ID] = cached
+	}
+	a.mu.Unlock()
+
+	cached.mu.Lock()
+	defer cached.mu.Unlock()
+	if cached.token != nil && cached.token.valid(time.Now()) {
+		return cached.token, nil
+	}
+	token, err := requestOAuth2Token(ctx, server)
+	if err != nil {
+		cached.token = nil
+		return nil, err
+	}
+	cached.token = token
+	return token, nil
+}
+
+// requestOAuth2Token asks the token endpoint of the server for a client credentials token
+func requestOAuth2Token(ctx context.Context, server *Server) (*oauth2Token, error) {
+	tokenURL := server.authParam("tokenURL", "")
+	if tokenURL == "" {
+		return nil, fmt.Errorf("server %s has no OAuth2 tokenURL", server.s.Name)
+	}
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if scope := server.authParam("scope", ""); scope != "" {
+		form.Set("scope", scope)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(server.s.Username), url.QueryEscape(server.s.Password))
+
+	client, err := server.HTTPClient()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("OAuth2 token request failed: %w", err)
+	}
+	body := drainBody(resp.Body, 1<<20)
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("OAuth2 token request failed with status %d: %s", resp.StatusCode, body)
+	}
+	var tr struct {
+		AccessToken string      `json:"access_token"`
+		TokenType   string      `json:"token_type"`
+		ExpiresIn   json.Number `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &tr); err != nil {
+		return nil, fmt.Errorf("invalid OAuth2 token response: %w", err)
+	}
+	if tr.AccessToken == "" {
+		return nil, fmt.Errorf("OAuth2 token response has no access_token")
+	}
+	token := &oauth2Token{AccessToken: tr.AccessToken, TokenType: tr.TokenType,
+		Expiry: time.Now().Add(oauth2DefaultTokenTTL)}
+	if seconds, err := tr.ExpiresIn.Int64(); err == nil && seconds > 0 {
+		token.Expiry = time.Now().Add(time.Duration(seconds) * time.Second)
+	}
+	return token, nil
+}
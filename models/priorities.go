@@ -0,0 +1,91 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// the range of request priorities, higher priorities are dispatched first
+const (
+	MinPriority = -10
+	MaxPriority = 10
+)
+
+// ErrReportTypePriorityNotFound is returned when a report type has no default priority
+var ErrReportTypePriorityNotFound = errors.New("report type has no default priority")
+
+// ReportTypePriority is the default priority of the requests of a report type
+type ReportTypePriority struct {
+	ReportType string    `db:"report_type" json:"reportType"`
+	Priority   int       `db:"priority" json:"priority"`
+	Created    time.Time `db:"created" json:"created"`
+	Updated    time.Time `db:"updated" json:"updated"`
+}
+
+// validPriority adds an error for field when priority is out of range
+func (fe *fieldErrors) validPriority(field string, priority int) {
+	if priority < MinPriority || priority > MaxPriority {
+		fe.add(field, fmt.Sprintf("must be between %d and %d", MinPriority, MaxPriority))
+	}
+}
+
+// ParsePriority parses the priority given to a request. An empty value leaves the priority
+// to the defaults of the report type and source
+func ParsePriority(value string) (*int, error) {
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+	var errs fieldErrors
+	priority, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		errs.add("priority", "must be a whole number")
+	} else {
+		errs.validPriority("priority", priority)
+	}
+	if len(errs) > 0 {
+		return nil, &ValidationError{Errors: errs}
+	}
+	return &priority, nil
+}
+
+// GetReportTypePriorities returns the default priorities of report types
+func GetReportTypePriorities(db sqlx.Queryer) ([]ReportTypePriority, error) {
+	priorities := []ReportTypePriority{}
+	err := sqlx.Select(db, &priorities,
+		"SELECT report_type, priority, created, updated FROM report_type_priorities ORDER BY report_type")
+	return priorities, err
+}
+
+// SetReportTypePriority sets the default priority of the requests of reportType
+func SetReportTypePriority(db sqlx.Queryer, reportType string, priority int) (ReportTypePriority, error) {
+	var errs fieldErrors
+	errs.required("reportType", reportType)
+	errs.validPriority("priority", priority)
+	if len(errs) > 0 {
+		return ReportTypePriority{}, &ValidationError{Errors: errs}
+	}
+	p := ReportTypePriority{}
+	err := sqlx.Get(db, &p, `
+INSERT INTO report_type_priorities (report_type, priority)
+VALUES ($1, $2)
+ON CONFLICT (report_type) DO UPDATE SET priority = EXCLUDED.priority, updated = current_timestamp
+RETURNING report_type, priority, created, updated`, reportType, priority)
+	return p, err
+}
+
+// DeleteReportTypePriority removes the default priority of reportType
+func DeleteReportTypePriority(db sqlx.Execer, reportType string) error {
+	res, err := db.Exec("DELETE FROM report_type_priorities WHERE report_type = $1", reportType)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w: %s", ErrReportTypePriorityNotFound, reportType)
+	}
+	return nil
+}
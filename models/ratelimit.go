@@ -0,0 +1,85 @@
+package models
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// ratePeriods maps the rate_period of a server to its duration
+var ratePeriods = map[string]time.Duration{
+	"second": time.Second,
+	"minute": time.Minute,
+}
+
+// tokenBucket allows rate requests per second with bursts of up to burst requests
+type tokenBucket struct {
+	tokens float64
+	rate   float64
+	burst  float64
+	last   time.Time
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+// RateLimiter keeps a token bucket for each rate limited destination. The buckets are per
+// process and not shared between integrator instances
+type RateLimiter struct {
+	sync.Mutex
+	buckets map[ServerID]*tokenBucket
+}
+
+// NewRateLimiter returns a rate limiter with full buckets
+func NewRateLimiter() *RateLimiter {
+	return &RateLimiter{buckets: make(map[ServerID]*tokenBucket)}
+}
+
+// Budgets returns how many requests each rate limited server may be sent right now and,
+// when some server has run out, how long until it earns its next request. Changes to
+// the limits of a server apply from the next call
+func (l *RateLimiter) Budgets(servers []Server) (map[ServerID]int, time.Duration) {
+	l.Lock()
+	defer l.Unlock()
+	now := time.Now()
+	budgets := make(map[ServerID]int)
+	var wait time.Duration
+	for _, srv := range servers {
+		if srv.s.RateLimit <= 0 {
+			delete(l.buckets, srv.s.ID)
+			continue
+		}
+		period, ok := ratePeriods[srv.s.RatePeriod]
+		if !ok {
+			period = time.Second
+		}
+		rate := float64(srv.s.RateLimit) / period.Seconds()
+		burst := math.Max(float64(srv.s.RateBurst), 1)
+		b, ok := l.buckets[srv.s.ID]
+		if !ok {
+			b = &tokenBucket{tokens: burst, last: now}
+			l.buckets[srv.s.ID] = b
+		}
+		b.rate, b.burst = rate, burst
+		b.refill(now)
+		budgets[srv.s.ID] = int(b.tokens)
+		if b.tokens < 1 {
+			next := time.Duration((1 - b.tokens) / rate * float64(time.Second))
+			if wait == 0 || next < wait {
+				wait = next
+			}
+		}
+	}
+	return budgets, wait
+}
+
+// Take uses up a request of the budget of destination
+func (l *RateLimiter) Take(destination ServerID) {
+	l.Lock()
+	defer l.Unlock()
+	if b, ok := l.buckets[destination]; ok {
+		b.tokens--
+	}
+}
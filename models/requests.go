@@ -1,6 +1,7 @@
 package models
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"github.com/gcinnovate/integrator/utils"
@@ -18,14 +19,15 @@ type RequestStatus string
 
 // constants for the status
 const (
-	RequestStatusReady     = RequestStatus("ready")
-	RequestStatusPending   = RequestStatus("pending")
-	RequestStatusExpired   = RequestStatus("expired")
-	RequestStatusCompleted = RequestStatus("completed")
-	RequestStatusFailed    = RequestStatus("failed")
-	RequestStatusError     = RequestStatus("error")
-	RequestStatusIgnored   = RequestStatus("ignored")
-	RequestStatusCanceled  = RequestStatus("canceled")
+	RequestStatusReady      = RequestStatus("ready")
+	RequestStatusPending    = RequestStatus("pending")
+	RequestStatusInProgress = RequestStatus("inprogress")
+	RequestStatusExpired    = RequestStatus("expired")
+	RequestStatusCompleted  = RequestStatus("completed")
+	RequestStatusFailed     = RequestStatus("failed")
+	RequestStatusError      = RequestStatus("error")
+	RequestStatusIgnored    = RequestStatus("ignored")
+	RequestStatusCanceled   = RequestStatus("canceled")
 )
 
 // Request represents our requests queue in the database
@@ -61,10 +63,12 @@ type Request struct {
 		BodyIsQueryParams  bool          `db:"body_is_query_param" 	json:"bodyIsQueryParams"` // whether body is to be used a query parameters
 		SubmissionID       string        `db:"submissionid" 		json:"submissionId"`            // a reference ID is source system
 		URLSuffix          string        `db:"url_suffix" 			json:"urlSuffix"`
+		Priority           *int          `db:"priority" json:"priority"` // unset until defaulted from the report type or source
 		Created            time.Time     `db:"created" 				json:"created"`
 		Updated            time.Time     `db:"updated" 				json:"updated"`
 		// OrgID              OrgID         `db:"org_id"          			json:"org_id"` // Lets add these later
 	}
+	deduplicated bool // set when an earlier request with the same submissionid was returned
 }
 
 // ID return the id of this request
@@ -92,6 +96,20 @@ func (r *Request) Errors() string { return r.r.Errors }
 // BodyIsQueryParams returns whether request body is used as query params
 func (r *Request) BodyIsQueryParams() bool { return r.r.BodyIsQueryParams }
 
+// SubmissionID returns the idempotency key the source used for this request
+func (r *Request) SubmissionID() string { return r.r.SubmissionID }
+
+// Priority returns the priority the request was queued with
+func (r *Request) Priority() int {
+	if r.r.Priority == nil {
+		return 0
+	}
+	return *r.r.Priority
+}
+
+// Deduplicated returns whether this is an earlier request returned instead of a new insert
+func (r *Request) Deduplicated() bool { return r.deduplicated }
+
 // Body returns the body or the request
 func (r *Request) Body() string { return r.r.Body }
 
@@ -115,17 +133,46 @@ func (r *Request) UpdatedOn() time.Time { return r.r.Updated }
 
 // NewRequest creates new request and saves it in DB
 func NewRequest(c *gin.Context, db *sqlx.DB) (Request, error) {
+	req, err := requestFromContext(c, db)
+	if err != nil {
+		return req, err
+	}
+	tx, err := db.Beginx()
+	if err != nil {
+		return req, err
+	}
+	defer func() { _ = tx.Rollback() }()
+	if err := req.insert(tx); err != nil {
+		return req, err
+	}
+	return req, tx.Commit()
+}
+
+// ValidateRequest builds the request from the context and validates it without queuing it
+func ValidateRequest(c *gin.Context, db *sqlx.DB) (Request, error) {
+	return requestFromContext(c, db)
+}
+
+// requestFromContext builds a request from the query parameters and body of the context.
+// The route and the body are validated, a *ValidationError is returned for bad bodies
+func requestFromContext(c *gin.Context, db *sqlx.DB) (Request, error) {
 	source := utils.GetServer(c.Query("source"))
 	destination := utils.GetServer(c.Query("destination"))
 	fmt.Printf("Source>: %v, Destination: %v", source, destination)
+	if err := checkRoute(db, source, destination, c.Query("source"), c.Query("destination")); err != nil {
+		return Request{}, err
+	}
 
-	req := &Request{}
+	req := Request{}
 	r := &req.r
 	r.Source = source
 	r.Destination = destination
 	r.UID = utils.GetUID()
 	r.ContentType = c.Request.Header.Get("Content-Type")
 	r.SubmissionID = c.Query("msgid")
+	if r.SubmissionID == "" {
+		r.SubmissionID = c.Request.Header.Get("Idempotency-Key")
+	}
 	r.BatchID = c.Query("batchid")
 	r.Period = c.Query("period")
 	r.Week = c.Query("week")
@@ -141,46 +188,82 @@ func NewRequest(c *gin.Context, db *sqlx.DB) (Request, error) {
 	r.ObjectType = c.Query("objectType")
 	r.Errors = c.Query("extras")
 	r.District = c.Query("district")
+	r.URLSuffix = c.Query("urlSuffix")
+	if err := ValidURLSuffix(r.URLSuffix); err != nil {
+		return req, &ValidationError{Errors: []FieldError{{Field: "urlSuffix", Message: err.Error()}}}
+	}
+	priority, err := ParsePriority(c.Query("priority"))
+	if err != nil {
+		return req, err
+	}
+	r.Priority = priority
 
 	r.Status = RequestStatusReady
 
-	switch r.ContentType {
-	case "application/json":
-		var body map[string]interface{} // validate based on dest system endpoint
-		if err := c.BindJSON(&body); err != nil {
-			fmt.Printf("Error reading json body %v", err)
-		}
-		b, _ := json.Marshal(body)
-		fmt.Println(string(b))
-		r.Body = string(b)
-	case "application/xml":
-		// var xmlBody interface{}
-		xmlBody, err := c.GetRawData()
-		if err != nil {
-			fmt.Printf("Error reading xml body %v", err)
+	body, err := c.GetRawData()
+	if err != nil {
+		return req, fmt.Errorf("failed to read request body: %w", err)
+	}
+	if err := ValidateBody(r.ObjectType, r.ContentType, body); err != nil {
+		return req, err
+	}
+	if r.BodyIsQueryParams {
+		if _, err := BodyQueryParams(string(body), r.ContentType); err != nil {
+			return req, &ValidationError{Errors: []FieldError{{Field: "body", Message: err.Error()}}}
 		}
-		r.Body = string(xmlBody)
-	default:
-		body, err := c.GetRawData()
-		if err != nil {
-			fmt.Printf("Error reading body %v", err)
+	}
+	if MediaType(r.ContentType) == "application/json" {
+		var compacted bytes.Buffer
+		if err := json.Compact(&compacted, body); err == nil {
+			body = compacted.Bytes()
 		}
-		r.Body = string(body)
 	}
+	r.Body = string(body)
+	return req, nil
+}
 
-	_, err := db.NamedExec(insertRequestSQL, r)
+// insert saves the request in the DB together with its copies to the cc_urls of the
+// destination. If the source already queued a request with the same submissionid, nothing
+// is inserted and the original request is loaded instead.
+func (req *Request) insert(db sqlx.Ext) error {
+	r := &req.r
+	rows, err := sqlx.NamedQuery(db, insertRequestSQL, r)
 	if err != nil {
-		fmt.Printf("ERROR INSERTING REQUEST", err)
+		return err
 	}
-
-	return *req, nil
+	defer rows.Close()
+	if rows.Next() {
+		if err := rows.StructScan(r); err != nil {
+			return err
+		}
+		rows.Close()
+		return insertCopies(db, r.ID, r.Destination)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	req.deduplicated = true
+	return sqlx.Get(db, r, selectRequestBySubmissionIDSQL, r.Source, r.SubmissionID)
 }
 
 const insertRequestSQL = `
 INSERT INTO 
 requests (source, destination, uid, batchid, ctype, body, body_is_query_param, period, week, month, year,
 			raw_msg, msisdn, facility, district, report_type, object_type, extras, url_suffix,
-			created, updated) 
+			submissionid, priority, created, updated) 
 	VALUES(:source, :destination, :uid, :batchid, :ctype, :body, :body_is_query_param, :period,
-			:week, :month, :year, :raw_msg, :msisdn, :facility, :district, :report_type, :object_type,
-			:extras, :url_suffix, now(), now())`
+			:week, :month, CAST(NULLIF(:year, '') AS INTEGER), :raw_msg, :msisdn, :facility, :district,
+			:report_type, :object_type, :extras, :url_suffix, :submissionid,
+			COALESCE(CAST(:priority AS INTEGER),
+				(SELECT priority FROM report_type_priorities WHERE report_type = :report_type),
+				(SELECT default_priority FROM servers WHERE id = :source), 0),
+			now(), now())
+	ON CONFLICT (source, submissionid) WHERE submissionid <> '' DO NOTHING
+	RETURNING id, status, priority, created, updated`
+
+const selectRequestBySubmissionIDSQL = `
+SELECT
+	id, uid, source, destination, batchid, ctype, body, status, COALESCE(statuscode, '') AS statuscode,
+	retries, COALESCE(errors, '') AS errors, period, raw_msg, submissionid, priority, created, updated
+FROM requests
+WHERE source = $1 AND submissionid = $2`
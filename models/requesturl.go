@@ -0,0 +1,131 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// ErrInvalidURLSuffix is returned for a url_suffix that is not a relative path
+var ErrInvalidURLSuffix = errors.New("url suffix must be a relative path, optionally with a query string")
+
+// ErrInvalidQueryParamsBody is returned for a body flagged as query params that cannot be encoded
+var ErrInvalidQueryParamsBody = errors.New("body sent as query params must be a JSON object or form encoded")
+
+// ValidURLSuffix checks that suffix can be appended to the URL of a destination
+func ValidURLSuffix(suffix string) error {
+	if suffix == "" {
+		return nil
+	}
+	u, err := url.Parse(suffix)
+	if err != nil || u.Scheme != "" || u.Host != "" || strings.HasPrefix(suffix, "//") {
+		return ErrInvalidURLSuffix
+	}
+	return nil
+}
+
+// AppendURLSuffix appends suffix to the path of base and merges the query string of the
+// suffix into the query of base
+func AppendURLSuffix(base, suffix string) (string, error) {
+	if suffix == "" {
+		return base, nil
+	}
+	if err := ValidURLSuffix(suffix); err != nil {
+		return "", err
+	}
+	u, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	s, _ := url.Parse(suffix)
+	if s.Path != "" {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + strings.TrimPrefix(s.Path, "/")
+		u.RawPath = ""
+	}
+	if s.RawQuery != "" {
+		u.RawQuery = mergeQuery(u.Query(), s.Query()).Encode()
+	}
+	return u.String(), nil
+}
+
+// WithQueryParams adds params to the query string of target
+func WithQueryParams(target string, params url.Values) (string, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", err
+	}
+	u.RawQuery = mergeQuery(u.Query(), params).Encode()
+	return u.String(), nil
+}
+
+// mergeQuery adds the values of extra to query, replacing values of the same key
+func mergeQuery(query, extra url.Values) url.Values {
+	for key, values := range extra {
+		query[key] = values
+	}
+	return query
+}
+
+// BodyQueryParams encodes a request body as query parameters. Form encoded bodies are used as
+// they are. The fields of a JSON object become parameters, arrays repeat the parameter and
+// nested objects are sent as JSON
+func BodyQueryParams(body, contentType string) (url.Values, error) {
+	if MediaType(contentType) == "application/x-www-form-urlencoded" {
+		params, err := url.ParseQuery(strings.TrimSpace(body))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidQueryParamsBody, err)
+		}
+		return params, nil
+	}
+	dec := json.NewDecoder(strings.NewReader(body))
+	dec.UseNumber()
+	var fields map[string]interface{}
+	if err := dec.Decode(&fields); err != nil || fields == nil {
+		return nil, ErrInvalidQueryParamsBody
+	}
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	params := url.Values{}
+	for _, key := range keys {
+		values, ok := fields[key].([]interface{})
+		if !ok {
+			values = []interface{}{fields[key]}
+		}
+		for _, v := range values {
+			s, err := queryValue(v)
+			if err != nil {
+				return nil, err
+			}
+			params.Add(key, s)
+		}
+	}
+	return params, nil
+}
+
+// queryValue formats a JSON value for the query string
+func queryValue(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return fmt.Sprint(v), nil
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
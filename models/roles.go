@@ -0,0 +1,228 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// errors returned when managing roles
+var (
+	ErrRoleNotFound = errors.New("role not found")
+	ErrRoleInUse    = errors.New("role is assigned to users")
+)
+
+// permissionFlags are the letters allowed in sys_perms: read, modify, add and delete
+const permissionFlags = "rmad"
+
+// the sys_perms flags
+const (
+	PermRead   = "r"
+	PermModify = "m"
+	PermAdd    = "a"
+	PermDelete = "d"
+)
+
+// the system modules permissions are granted on
+const (
+	ModuleUsers   = "Users"
+	ModuleRoles   = "Roles"
+	ModuleServers = "Servers" // servers, their circuits and allowed sources
+	ModuleQueue   = "Queue"   // dead letters, report type priorities and callbacks
+)
+
+// UserRole is a role assigned to users
+type UserRole struct {
+	ID          int64     `db:"id" json:"id"`
+	Name        string    `db:"name" json:"name"`
+	Description string    `db:"description" json:"description"`
+	Created     time.Time `db:"created" json:"created"`
+	Updated     time.Time `db:"updated" json:"updated"`
+}
+
+// RoleParams are the fields of a role set through the API
+type RoleParams struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+}
+
+// Permission grants a role the sys_perms flags on a system module
+type Permission struct {
+	Module string `db:"sys_module" json:"module"`
+	Perms  string `db:"sys_perms" json:"perms"`
+}
+
+const selectRoleSQL = `SELECT id, name, COALESCE(description, '') AS description, created, updated FROM user_roles`
+
+// GetRoles returns all the roles ordered by name
+func GetRoles(db sqlx.Queryer) ([]UserRole, error) {
+	roles := []UserRole{}
+	err := sqlx.Select(db, &roles, selectRoleSQL+" ORDER BY name")
+	return roles, err
+}
+
+// GetRole returns the role with the given id
+func GetRole(db sqlx.Queryer, id int64) (UserRole, error) {
+	role := UserRole{}
+	err := sqlx.Get(db, &role, selectRoleSQL+" WHERE id = $1", id)
+	if err == sql.ErrNoRows {
+		return role, fmt.Errorf("%w: %d", ErrRoleNotFound, id)
+	}
+	return role, err
+}
+
+// uniqueRoleError turns a duplicate role name into a validation error
+func uniqueRoleError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" { // unique_violation
+		return &ValidationError{Errors: []FieldError{{Field: "name", Message: "is already taken"}}}
+	}
+	return err
+}
+
+// CreateRole stores a new role
+func CreateRole(db sqlx.Queryer, params RoleParams) (UserRole, error) {
+	role := UserRole{}
+	params.apply(&role)
+	if err := role.validate(); err != nil {
+		return role, err
+	}
+	var id int64
+	err := sqlx.Get(db, &id,
+		"INSERT INTO user_roles (name, description) VALUES ($1, $2) RETURNING id", role.Name, role.Description)
+	if err != nil {
+		return role, uniqueRoleError(err)
+	}
+	return GetRole(db, id)
+}
+
+// UpdateRole applies params to the role with the given id
+func UpdateRole(db sqlx.Queryer, id int64, params RoleParams) (UserRole, error) {
+	role, err := GetRole(db, id)
+	if err != nil {
+		return role, err
+	}
+	params.apply(&role)
+	if err := role.validate(); err != nil {
+		return role, err
+	}
+	err = sqlx.Get(db, &role, `
+UPDATE user_roles SET name = $2, description = $3, updated = current_timestamp
+WHERE id = $1
+RETURNING id, name, COALESCE(description, '') AS description, created, updated`, id, role.Name, role.Description)
+	return role, uniqueRoleError(err)
+}
+
+// DeleteRole removes the role with the given id and its permissions.
+// Roles still assigned to users cannot be deleted
+func DeleteRole(db sqlx.Execer, id int64) error {
+	res, err := db.Exec("DELETE FROM user_roles WHERE id = $1", id)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23503" { // foreign_key_violation
+		return fmt.Errorf("%w: %d", ErrRoleInUse, id)
+	}
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w: %d", ErrRoleNotFound, id)
+	}
+	return nil
+}
+
+func (p *RoleParams) apply(role *UserRole) {
+	if p.Name != nil {
+		role.Name = strings.TrimSpace(*p.Name)
+	}
+	if p.Description != nil {
+		role.Description = strings.TrimSpace(*p.Description)
+	}
+}
+
+func (role *UserRole) validate() error {
+	var errs fieldErrors
+	errs.required("name", role.Name)
+	if len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
+	return nil
+}
+
+// GetRolePermissions returns the permissions of the role with the given id
+func GetRolePermissions(db sqlx.Queryer, id int64) ([]Permission, error) {
+	if _, err := GetRole(db, id); err != nil {
+		return nil, err
+	}
+	perms := []Permission{}
+	err := sqlx.Select(db, &perms,
+		"SELECT sys_module, sys_perms FROM user_role_permissions WHERE user_role = $1 ORDER BY sys_module", id)
+	return perms, err
+}
+
+// SetRolePermissions replaces the permissions of the role with the given id. Perms are
+// made up of the letters r (read), m (modify), a (add) and d (delete)
+func SetRolePermissions(db *sqlx.DB, id int64, perms []Permission) ([]Permission, error) {
+	var errs fieldErrors
+	modules := map[string]bool{}
+	for i, p := range perms {
+		p.Module = strings.TrimSpace(p.Module)
+		field := fmt.Sprintf("permissions[%d]", i)
+		errs.required(field+".module", p.Module)
+		if modules[p.Module] {
+			errs.add(field+".module", "is listed more than once")
+		}
+		modules[p.Module] = true
+		for _, flag := range p.Perms {
+			if !strings.ContainsRune(permissionFlags, flag) || strings.Count(p.Perms, string(flag)) > 1 {
+				errs.add(field+".perms", "must be made up of the letters r, m, a and d")
+				break
+			}
+		}
+		perms[i] = p
+	}
+	if len(errs) > 0 {
+		return nil, &ValidationError{Errors: errs}
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+	if _, err := GetRole(tx, id); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec("DELETE FROM user_role_permissions WHERE user_role = $1", id); err != nil {
+		return nil, err
+	}
+	for _, p := range perms {
+		_, err := tx.Exec(
+			"INSERT INTO user_role_permissions (user_role, sys_module, sys_perms) VALUES ($1, $2, $3)",
+			id, p.Module, p.Perms)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return GetRolePermissions(db, id)
+}
+
+// HasPermission returns whether the role of the active user with the given id grants the
+// sys_perms flag on module
+func HasPermission(db sqlx.Queryer, userID int64, module, flag string) (bool, error) {
+	var allowed bool
+	err := sqlx.Get(db, &allowed, `
+SELECT EXISTS(
+	SELECT 1 FROM users u
+	INNER JOIN user_role_permissions p ON p.user_role = u.user_role
+	WHERE u.id = $1 AND u.is_active AND p.sys_module = $2 AND strpos(p.sys_perms, $3) > 0)`,
+		userID, module, flag)
+	return allowed, err
+}
@@ -1,75 +1,183 @@
 package models
 
 import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gcinnovate/integrator/db"
+	"github.com/gcinnovate/integrator/utils"
+	"github.com/gcinnovate/integrator/utils/httpclient"
+	"github.com/jmoiron/sqlx"
 	"github.com/lib/pq"
 )
 
-func init() {
-	rows, err := db.GetDB().Queryx("SELECT * FROM servers")
-	defer rows.Close()
+// ServerMap is the List of Servers
+var ServerMap map[string]Server
+
+// serverMapLock guards ServerMap, which is reloaded whenever servers change
+var serverMapLock sync.RWMutex
 
+// LoadServers (re)loads ServerMap from the servers table. It is first called once the migrations
+// have run, and again whenever a server changes
+func LoadServers() error {
+	rows, err := db.GetDB().Queryx("SELECT * FROM servers")
 	if err != nil {
-		log.Fatalf("Failed to load servers", err)
+		return fmt.Errorf("failed to load servers: %w", err)
 	}
-	ServerMap = make(map[string]Server)
+	defer rows.Close()
+
+	servers := make(map[string]Server)
 	for rows.Next() {
 		srv := &Server{}
 		s := &srv.s
-		err := rows.StructScan(&s)
+		err := rows.StructScan(s)
 		if err != nil {
-			log.Fatalln("Server Loading ==>", err)
+			return err
 		}
-		// fmt.Printf("=>>>>>>%#v", s)
-		ServerMap[strconv.Itoa(int(s.ID))] = *srv
+		servers[strconv.Itoa(int(s.ID))] = *srv
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	serverMapLock.Lock()
+	ServerMap = servers
+	serverMapLock.Unlock()
+	return nil
+}
 
+// Servers returns the loaded configuration of all servers
+func Servers() []Server {
+	serverMapLock.RLock()
+	defer serverMapLock.RUnlock()
+	servers := make([]Server, 0, len(ServerMap))
+	for _, srv := range ServerMap {
+		servers = append(servers, srv)
 	}
+	return servers
 }
 
-// ServerMap is the List of Servers
-var ServerMap map[string]Server
+// LookupServer returns the loaded configuration of the server with the given id
+func LookupServer(id int) (Server, bool) {
+	serverMapLock.RLock()
+	defer serverMapLock.RUnlock()
+	srv, ok := ServerMap[strconv.Itoa(id)]
+	return srv, ok
+}
 
 // ServerID is the id for the server
 type ServerID int64
 
 // Server is our user object
 type Server struct {
-	s struct {
-		ID                      ServerID               `db:"id" 						json:"-"`
-		UID                     string                 `db:"uid" 					json:"uid"`
-		Name                    string                 `db:"name" 					json:"name"`
-		Username                string                 `db:"username"				json:"username"`
-		Password                string                 `db:"password"				json:"password"`
-		IsProxyServer           bool                   `db:"is_proxy_server"			json:"is_proxy_server"` // whether response is received as is
-		SystemType              string                 `db:"system_type"				json:"system_type"`        // the type of system e.g DHIS2, Other is the default
-		EndPointType            string                 `db:"endpoint_type"			json:"endpoint_type"`     // e.g /dataValueSets,
-		AuthToken               string                 `db:"auth_token"				json:"auth_token"`
-		IPAddress               string                 `db:"ipaddress"				json:"ipaddress"` // Usefull for setting Trusted Proxies
-		URL                     string                 `db:"url"						json:"url"`
-		CCURLS                  pq.StringArray         `db:"cc_urls"					json:"cc_urls"`              // just an additional URL to receive same request
-		CallbackURL             string                 `db:"callback_url" 			json:"callback_url"`     // receives response on success call to url
-		HTTPMethod              string                 `db:"http_method"				json:"http_method"`       // the HTTP Method used when calling the url
-		AuthMethod              string                 `db:"auth_method"				json:"auth_method"`       // the Authentication Method used
-		AllowCallbacks          bool                   `db:"allow_callbacks"			json:"allowCallbacks"` // Whether to allow calling sending callbacks
-		AllowCopies             bool                   `db:"allow_copies"			json:"allowCopies"`       // Whether to allow copying similar request to CCURLs
-		UseAsync                bool                   `db:"use_async" 				json:"use_async"`
-		UseSSL                  bool                   `db:"use_ssl" 				json:"use_ssl"`
-		ParseResponses          bool                   `db:"parse_responses" 		json:"parseResponses"`
-		SSLClientCertKeyFile    string                 `db:"ssl_client_certkey_file"	json:"sslClientCertkeyFile"`
-		StartOfSubmissionPeriod string                 `db:"start_submission_period"	json:"startSubmissionPeriod"`
-		EndOfSubmissionPeriod   string                 `db:"end_submission_period"	json:"endSubmissionPeriod"`
-		XMLResponseXPATH        string                 `db:"xml_response_xpath" 		json:"xml_response_xpath"`
-		JSONResponseXPATH       string                 `db:"json_response_xpath" 	json:"json_response_xpath"`
-		Suspended               bool                   `db:"suspended" 				json:"suspended"`
-		URLParams               map[string]interface{} `db:"url_params" json:"URLParams"`
-		Created                 time.Time              `db:"created" 				json:"created"`
-		Updated                 time.Time              `db:"updated" 				json:"updated"`
+	s serverFields
+}
+
+type serverFields struct {
+	ID                      ServerID       `db:"id" json:"id"`
+	UID                     string         `db:"uid" json:"uid"`
+	Name                    string         `db:"name" json:"name"`
+	Username                string         `db:"username" json:"username"`
+	Password                string         `db:"password" json:"-"`
+	IsProxyServer           bool           `db:"is_proxy_server" json:"is_proxy_server"` // whether response is received as is
+	SystemType              string         `db:"system_type" json:"system_type"`         // the type of system e.g DHIS2, Other is the default
+	EndPointType            string         `db:"endpoint_type" json:"endpoint_type"`     // e.g /dataValueSets,
+	AuthToken               string         `db:"auth_token" json:"-"`
+	IPAddress               string         `db:"ipaddress" json:"ipaddress"` // Usefull for setting Trusted Proxies
+	URL                     string         `db:"url" json:"url"`
+	CCURLS                  pq.StringArray `db:"cc_urls" json:"cc_urls"`                // just an additional URL to receive same request
+	CallbackURL             string         `db:"callback_url" json:"callback_url"`      // receives response on success call to url
+	HTTPMethod              string         `db:"http_method" json:"http_method"`        // the HTTP Method used when calling the url
+	AuthMethod              string         `db:"auth_method" json:"auth_method"`        // the Authentication Method used
+	AuthParams              AuthParams     `db:"auth_params" json:"authParams"`         // settings of the auth method
+	AllowCallbacks          bool           `db:"allow_callbacks" json:"allowCallbacks"` // Whether to allow calling sending callbacks
+	AllowCopies             bool           `db:"allow_copies" json:"allowCopies"`       // Whether to allow copying similar request to CCURLs
+	UseAsync                bool           `db:"use_async" json:"use_async"`
+	UseSSL                  bool           `db:"use_ssl" json:"use_ssl"`
+	ParseResponses          bool           `db:"parse_responses" json:"parseResponses"`
+	SSLClientCertKeyFile    string         `db:"ssl_client_certkey_file" json:"sslClientCertkeyFile"`
+	SSLCABundleFile         string         `db:"ssl_ca_bundle_file" json:"sslCABundleFile"`
+	SSLInsecure             bool           `db:"ssl_insecure" json:"sslInsecure"` // skip certificate verification
+	StartOfSubmissionPeriod int            `db:"start_submission_period" json:"startSubmissionPeriod"`
+	EndOfSubmissionPeriod   int            `db:"end_submission_period" json:"endSubmissionPeriod"`
+	XMLResponseXPATH        string         `db:"xml_response_xpath" json:"xml_response_xpath"`
+	JSONResponseXPATH       string         `db:"json_response_xpath" json:"json_response_xpath"`
+	Suspended               bool           `db:"suspended" json:"suspended"`
+	URLParams               URLParams      `db:"url_params" json:"URLParams"`
+	JSONTransforms          JSONTransforms `db:"json_transforms" json:"jsonTransforms"`  // by object type
+	RetryBaseDelay          int            `db:"retry_base_delay" json:"retryBaseDelay"` // seconds before the first retry
+	RetryMaxDelay           int            `db:"retry_max_delay" json:"retryMaxDelay"`
+	RetryMultiplier         float64        `db:"retry_multiplier" json:"retryMultiplier"`
+	RetryJitter             float64        `db:"retry_jitter" json:"retryJitter"`
+	MaxInFlight             int            `db:"max_in_flight" json:"maxInFlight"` // concurrent requests, 0 is unlimited
+	RateLimit               int            `db:"rate_limit" json:"rateLimit"`      // requests per rate period, 0 is unlimited
+	RatePeriod              string         `db:"rate_period" json:"ratePeriod"`    // second or minute
+	RateBurst               int            `db:"rate_burst" json:"rateBurst"`
+	CircuitState            CircuitState   `db:"circuit_state" json:"circuitState"`
+	CircuitFailures         int            `db:"circuit_failures" json:"circuitFailures"`
+	CircuitFailureThreshold int            `db:"circuit_failure_threshold" json:"circuitFailureThreshold"` // 0 disables the breaker
+	CircuitProbeInterval    int            `db:"circuit_probe_interval" json:"circuitProbeInterval"`       // seconds between probes
+	CircuitProbeURL         string         `db:"circuit_probe_url" json:"circuitProbeURL"`
+	CircuitOpenedAt         *time.Time     `db:"circuit_opened_at" json:"circuitOpenedAt"`
+	DefaultPriority         int            `db:"default_priority" json:"defaultPriority"` // of requests from this source
+	Created                 time.Time      `db:"created" json:"created"`
+	Updated                 time.Time      `db:"updated" json:"updated"`
+}
+
+// MarshalJSON returns the JSON representation of the server. The password and auth token
+// are never included, only whether they are set
+func (s Server) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		serverFields
+		HasPassword  bool `json:"hasPassword"`
+		HasAuthToken bool `json:"hasAuthToken"`
+	}{s.s, s.s.Password != "", s.s.AuthToken != ""})
+}
+
+// URLParams are the extra query parameters added to the URL of a server
+type URLParams map[string]interface{}
+
+// Value implements the driver.Valuer interface for the url_params JSONB column
+func (p URLParams) Value() (driver.Value, error) {
+	if p == nil {
+		return "{}", nil
+	}
+	b, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Scan implements the sql.Scanner interface for the url_params JSONB column
+func (p *URLParams) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case nil:
+		*p = URLParams{}
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return fmt.Errorf("cannot scan %T into URLParams", src)
+	}
+	params := URLParams{}
+	if err := json.Unmarshal(data, &params); err != nil {
+		return err
 	}
+	*p = params
+	return nil
 }
 
 // ServerAllowedApps hold servers and servers they allow to communicate with
@@ -103,6 +211,35 @@ func (s *Server) AuthToken() string { return s.s.AuthToken }
 // URL returns the URL for the server
 func (s *Server) URL() string { return s.s.URL }
 
+// TLSOptions returns the TLS settings of the connections to the server
+func (s *Server) TLSOptions() httpclient.TLSOptions {
+	return httpclient.TLSOptions{
+		CABundleFile: s.s.SSLCABundleFile,
+		CertKeyFile:  s.s.SSLClientCertKeyFile,
+		Insecure:     s.s.SSLInsecure,
+	}
+}
+
+// HTTPClient returns the shared client for calls to the server, built from its TLS settings
+func (s *Server) HTTPClient() (*http.Client, error) {
+	return httpclient.Client(fmt.Sprintf("server:%d", s.s.ID), s.TLSOptions())
+}
+
+// CopyURLs returns the cc_urls that receive a copy of every request to the server,
+// none unless the server allows copies
+func (s *Server) CopyURLs() []string {
+	if !s.s.AllowCopies {
+		return nil
+	}
+	urls := []string{}
+	for _, u := range s.s.CCURLS {
+		if u != "" {
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
+
 // HTTPMethod returns the method used when calling the URL
 func (s *Server) HTTPMethod() string { return s.s.HTTPMethod }
 
@@ -121,15 +258,44 @@ func (s *Server) CallbackURL() string { return s.s.CallbackURL }
 // ParseResponses return whether we shold parse the server's responses
 func (s *Server) ParseResponses() bool { return s.s.ParseResponses }
 
-// EndOfSubmissionPeriod returns the end of the submission period for the server
-func (s *Server) EndOfSubmissionPeriod() string { return s.s.EndOfSubmissionPeriod }
+// EndOfSubmissionPeriod returns the hour at which the submission period of the server ends
+func (s *Server) EndOfSubmissionPeriod() int { return s.s.EndOfSubmissionPeriod }
 
-// StartOfSubmissionPeriod returns the start of the submission period for the server
-func (s *Server) StartOfSubmissionPeriod() string { return s.s.StartOfSubmissionPeriod }
+// StartOfSubmissionPeriod returns the hour at which the submission period of the server starts
+func (s *Server) StartOfSubmissionPeriod() int { return s.s.StartOfSubmissionPeriod }
 
 // Suspended returns whether the server is suspended
 func (s *Server) Suspended() bool { return s.s.Suspended }
 
+// RetryPolicy returns the backoff used when retrying failed requests to the server
+func (s *Server) RetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		BaseDelay:  time.Duration(s.s.RetryBaseDelay) * time.Second,
+		MaxDelay:   time.Duration(s.s.RetryMaxDelay) * time.Second,
+		Multiplier: s.s.RetryMultiplier,
+		Jitter:     s.s.RetryJitter,
+	}
+}
+
+// APIURL returns the URL of path under the DHIS2 api of the server, the api being
+// found from the path of the server URL
+func (s *Server) APIURL(path string) (string, error) {
+	u, err := url.Parse(s.s.URL)
+	if err != nil {
+		return "", err
+	}
+	base := u.Path
+	if i := strings.Index(base, "/api"); i >= 0 {
+		base = base[:i]
+	}
+	u.Path = strings.TrimSuffix(base, "/") + "/api/" + strings.TrimPrefix(path, "/")
+	u.RawQuery = ""
+	return u.String(), nil
+}
+
+// MaxInFlight returns how many requests may be sent to the server at once, 0 is unlimited
+func (s *Server) MaxInFlight() int { return s.s.MaxInFlight }
+
 // CreatedOn return time when Server/App was created
 func (s *Server) CreatedOn() time.Time { return s.s.Created }
 
@@ -148,3 +314,426 @@ func GetServerByID(id int64) Server {
 	return srv
 
 }
+
+// ErrServerInUse is returned when deleting a server that requests still refer to
+var ErrServerInUse = errors.New("server is referenced by requests")
+
+// ServerParams are the fields of a server set through the API. Fields left out of
+// an update keep their current value
+type ServerParams struct {
+	UID                     *string         `json:"uid"`
+	Name                    *string         `json:"name"`
+	Username                *string         `json:"username"`
+	Password                *string         `json:"password"`
+	IsProxyServer           *bool           `json:"is_proxy_server"`
+	SystemType              *string         `json:"system_type"`
+	EndPointType            *string         `json:"endpoint_type"`
+	AuthToken               *string         `json:"auth_token"`
+	IPAddress               *string         `json:"ipaddress"`
+	URL                     *string         `json:"url"`
+	CCURLS                  *[]string       `json:"cc_urls"`
+	CallbackURL             *string         `json:"callback_url"`
+	HTTPMethod              *string         `json:"http_method"`
+	AuthMethod              *string         `json:"auth_method"`
+	AuthParams              *AuthParams     `json:"authParams"`
+	AllowCallbacks          *bool           `json:"allowCallbacks"`
+	AllowCopies             *bool           `json:"allowCopies"`
+	UseAsync                *bool           `json:"use_async"`
+	UseSSL                  *bool           `json:"use_ssl"`
+	ParseResponses          *bool           `json:"parseResponses"`
+	SSLClientCertKeyFile    *string         `json:"sslClientCertkeyFile"`
+	SSLCABundleFile         *string         `json:"sslCABundleFile"`
+	SSLInsecure             *bool           `json:"sslInsecure"`
+	StartOfSubmissionPeriod *int            `json:"startSubmissionPeriod"`
+	EndOfSubmissionPeriod   *int            `json:"endSubmissionPeriod"`
+	XMLResponseXPATH        *string         `json:"xml_response_xpath"`
+	JSONResponseXPATH       *string         `json:"json_response_xpath"`
+	Suspended               *bool           `json:"suspended"`
+	URLParams               *URLParams      `json:"URLParams"`
+	JSONTransforms          *JSONTransforms `json:"jsonTransforms"`
+	RetryBaseDelay          *int            `json:"retryBaseDelay"`
+	RetryMaxDelay           *int            `json:"retryMaxDelay"`
+	RetryMultiplier         *float64        `json:"retryMultiplier"`
+	RetryJitter             *float64        `json:"retryJitter"`
+	MaxInFlight             *int            `json:"maxInFlight"`
+	RateLimit               *int            `json:"rateLimit"`
+	RatePeriod              *string         `json:"ratePeriod"`
+	RateBurst               *int            `json:"rateBurst"`
+	CircuitFailureThreshold *int            `json:"circuitFailureThreshold"`
+	CircuitProbeInterval    *int            `json:"circuitProbeInterval"`
+	CircuitProbeURL         *string         `json:"circuitProbeURL"`
+	DefaultPriority         *int            `json:"defaultPriority"`
+}
+
+// apply copies the fields set in p onto s
+func (p *ServerParams) apply(s *serverFields) {
+	setString := func(dst *string, src *string) {
+		if src != nil {
+			*dst = strings.TrimSpace(*src)
+		}
+	}
+	setBool := func(dst *bool, src *bool) {
+		if src != nil {
+			*dst = *src
+		}
+	}
+	setString(&s.UID, p.UID)
+	setString(&s.Name, p.Name)
+	setString(&s.Username, p.Username)
+	if p.Password != nil { // secrets are kept verbatim
+		s.Password = *p.Password
+	}
+	if p.AuthToken != nil {
+		s.AuthToken = *p.AuthToken
+	}
+	setBool(&s.IsProxyServer, p.IsProxyServer)
+	setString(&s.SystemType, p.SystemType)
+	setString(&s.EndPointType, p.EndPointType)
+	setString(&s.IPAddress, p.IPAddress)
+	setString(&s.URL, p.URL)
+	if p.CCURLS != nil {
+		s.CCURLS = pq.StringArray{}
+		for _, u := range *p.CCURLS {
+			s.CCURLS = append(s.CCURLS, strings.TrimSpace(u))
+		}
+	}
+	setString(&s.CallbackURL, p.CallbackURL)
+	if p.HTTPMethod != nil {
+		s.HTTPMethod = strings.ToUpper(strings.TrimSpace(*p.HTTPMethod))
+	}
+	setString(&s.AuthMethod, p.AuthMethod)
+	if p.AuthParams != nil {
+		s.AuthParams = *p.AuthParams
+	}
+	setBool(&s.AllowCallbacks, p.AllowCallbacks)
+	setBool(&s.AllowCopies, p.AllowCopies)
+	setBool(&s.UseAsync, p.UseAsync)
+	setBool(&s.UseSSL, p.UseSSL)
+	setBool(&s.ParseResponses, p.ParseResponses)
+	setString(&s.SSLClientCertKeyFile, p.SSLClientCertKeyFile)
+	setString(&s.SSLCABundleFile, p.SSLCABundleFile)
+	setBool(&s.SSLInsecure, p.SSLInsecure)
+	if p.StartOfSubmissionPeriod != nil {
+		s.StartOfSubmissionPeriod = *p.StartOfSubmissionPeriod
+	}
+	if p.EndOfSubmissionPeriod != nil {
+		s.EndOfSubmissionPeriod = *p.EndOfSubmissionPeriod
+	}
+	setString(&s.XMLResponseXPATH, p.XMLResponseXPATH)
+	setString(&s.JSONResponseXPATH, p.JSONResponseXPATH)
+	setBool(&s.Suspended, p.Suspended)
+	if p.URLParams != nil {
+		s.URLParams = *p.URLParams
+	}
+	if p.JSONTransforms != nil {
+		s.JSONTransforms = *p.JSONTransforms
+	}
+	if p.RetryBaseDelay != nil {
+		s.RetryBaseDelay = *p.RetryBaseDelay
+	}
+	if p.RetryMaxDelay != nil {
+		s.RetryMaxDelay = *p.RetryMaxDelay
+	}
+	if p.RetryMultiplier != nil {
+		s.RetryMultiplier = *p.RetryMultiplier
+	}
+	if p.RetryJitter != nil {
+		s.RetryJitter = *p.RetryJitter
+	}
+	if p.MaxInFlight != nil {
+		s.MaxInFlight = *p.MaxInFlight
+	}
+	if p.DefaultPriority != nil {
+		s.DefaultPriority = *p.DefaultPriority
+	}
+	if p.RateLimit != nil {
+		s.RateLimit = *p.RateLimit
+	}
+	if p.RatePeriod != nil {
+		s.RatePeriod = strings.ToLower(strings.TrimSpace(*p.RatePeriod))
+	}
+	if p.RateBurst != nil {
+		s.RateBurst = *p.RateBurst
+	}
+	if p.CircuitFailureThreshold != nil {
+		s.CircuitFailureThreshold = *p.CircuitFailureThreshold
+	}
+	if p.CircuitProbeInterval != nil {
+		s.CircuitProbeInterval = *p.CircuitProbeInterval
+	}
+	setString(&s.CircuitProbeURL, p.CircuitProbeURL)
+}
+
+// validURL adds an error for field unless value is an absolute http(s) URL
+func (fe *fieldErrors) validURL(field, value string) {
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		fe.add(field, "must be an absolute http or https URL")
+	}
+}
+
+// validate checks the complete configuration of a server
+func (s *serverFields) validate() error {
+	var errs fieldErrors
+	errs.required("name", s.Name)
+	if s.URL == "" {
+		errs.add("url", "is required")
+	} else {
+		errs.validURL("url", s.URL)
+	}
+	for i, u := range s.CCURLS {
+		errs.validURL(fmt.Sprintf("cc_urls[%d]", i), u)
+	}
+	if s.CallbackURL != "" {
+		errs.validURL("callback_url", s.CallbackURL)
+	}
+	if s.SSLCABundleFile != "" {
+		if _, err := httpclient.TLSConfig(httpclient.TLSOptions{CABundleFile: s.SSLCABundleFile}); err != nil {
+			errs.add("sslCABundleFile", err.Error())
+		}
+	}
+	if s.SSLClientCertKeyFile != "" {
+		if _, err := httpclient.TLSConfig(httpclient.TLSOptions{CertKeyFile: s.SSLClientCertKeyFile}); err != nil {
+			errs.add("sslClientCertkeyFile", err.Error())
+		}
+	}
+	switch s.HTTPMethod {
+	case http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+	default:
+		errs.add("http_method", "must be one of GET, POST, PUT, PATCH or DELETE")
+	}
+	if a, ok := lookupAuthenticator(s.AuthMethod); !ok {
+		errs.add("auth_method", fmt.Sprintf("must be empty or one of %q", AuthMethods()))
+	} else {
+		errs = append(errs, a.Check(&Server{s: *s})...)
+	}
+	errs.validJSONTransforms("jsonTransforms", s.JSONTransforms)
+	if s.StartOfSubmissionPeriod < 0 || s.StartOfSubmissionPeriod > 24 {
+		errs.add("startSubmissionPeriod", "must be an hour between 0 and 24")
+	}
+	if s.EndOfSubmissionPeriod < 0 || s.EndOfSubmissionPeriod > 24 {
+		errs.add("endSubmissionPeriod", "must be an hour between 0 and 24")
+	}
+	if s.StartOfSubmissionPeriod > s.EndOfSubmissionPeriod {
+		errs.add("endSubmissionPeriod", "must not be before startSubmissionPeriod")
+	}
+	if s.RetryBaseDelay < 1 {
+		errs.add("retryBaseDelay", "must be at least 1 second")
+	}
+	if s.RetryMaxDelay < s.RetryBaseDelay {
+		errs.add("retryMaxDelay", "must not be less than retryBaseDelay")
+	}
+	if s.RetryMultiplier < 1 {
+		errs.add("retryMultiplier", "must be at least 1")
+	}
+	if s.RetryJitter < 0 || s.RetryJitter > 1 {
+		errs.add("retryJitter", "must be between 0 and 1")
+	}
+	if s.MaxInFlight < 0 {
+		errs.add("maxInFlight", "must not be negative")
+	}
+	errs.validPriority("defaultPriority", s.DefaultPriority)
+	if s.RateLimit < 0 {
+		errs.add("rateLimit", "must not be negative")
+	}
+	if _, ok := ratePeriods[s.RatePeriod]; !ok {
+		errs.add("ratePeriod", `must be "second" or "minute"`)
+	}
+	if s.RateBurst < 0 {
+		errs.add("rateBurst", "must not be negative")
+	}
+	if s.CircuitFailureThreshold < 0 {
+		errs.add("circuitFailureThreshold", "must not be negative")
+	}
+	if s.CircuitProbeInterval < 1 {
+		errs.add("circuitProbeInterval", "must be at least 1 second")
+	}
+	if s.CircuitProbeURL != "" {
+		errs.validURL("circuitProbeURL", s.CircuitProbeURL)
+	}
+	if len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
+	return nil
+}
+
+// GetServers returns all the configured servers ordered by name
+func GetServers(db sqlx.Queryer) ([]Server, error) {
+	rows, err := db.Queryx("SELECT * FROM servers ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	servers := []Server{}
+	for rows.Next() {
+		srv := Server{}
+		if err := rows.StructScan(&srv.s); err != nil {
+			return nil, err
+		}
+		servers = append(servers, srv)
+	}
+	return servers, rows.Err()
+}
+
+// GetServer returns the server with the given id
+func GetServer(db sqlx.Queryer, id ServerID) (Server, error) {
+	srv := Server{}
+	err := sqlx.Get(db, &srv.s, "SELECT * FROM servers WHERE id = $1", id)
+	if err == sql.ErrNoRows {
+		return srv, fmt.Errorf("%w: %d", ErrServerNotFound, id)
+	}
+	return srv, err
+}
+
+const insertServerSQL = `
+INSERT INTO servers (uid, name, username, password, is_proxy_server, system_type, endpoint_type,
+	auth_token, ipaddress, url, cc_urls, callback_url, http_method, auth_method, allow_callbacks,
+	allow_copies, use_async, use_ssl, parse_responses, ssl_client_certkey_file, start_submission_period,
+	end_submission_period, xml_response_xpath, json_response_xpath, suspended, url_params,
+	retry_base_delay, retry_max_delay, retry_multiplier, retry_jitter, max_in_flight, rate_limit,
+	rate_period, rate_burst, circuit_failure_threshold, circuit_probe_interval, circuit_probe_url,
+	default_priority, ssl_ca_bundle_file, ssl_insecure, auth_params, json_transforms)
+VALUES (:uid, :name, :username, :password, :is_proxy_server, :system_type, :endpoint_type,
+	:auth_token, :ipaddress, :url, :cc_urls, :callback_url, :http_method, :auth_method, :allow_callbacks,
+	:allow_copies, :use_async, :use_ssl, :parse_responses, :ssl_client_certkey_file, :start_submission_period,
+	:end_submission_period, :xml_response_xpath, :json_response_xpath, :suspended, :url_params,
+	:retry_base_delay, :retry_max_delay, :retry_multiplier, :retry_jitter, :max_in_flight, :rate_limit,
+	:rate_period, :rate_burst, :circuit_failure_threshold, :circuit_probe_interval, :circuit_probe_url,
+	:default_priority, :ssl_ca_bundle_file, :ssl_insecure, :auth_params, :json_transforms)
+RETURNING *`
+
+const updateServerSQL = `
+UPDATE servers SET
+	uid = :uid, name = :name, username = :username, password = :password,
+	is_proxy_server = :is_proxy_server, system_type = :system_type, endpoint_type = :endpoint_type,
+	auth_token = :auth_token, ipaddress = :ipaddress, url = :url, cc_urls = :cc_urls,
+	callback_url = :callback_url, http_method = :http_method, auth_method = :auth_method,
+	allow_callbacks = :allow_callbacks, allow_copies = :allow_copies, use_async = :use_async,
+	use_ssl = :use_ssl, parse_responses = :parse_responses, ssl_client_certkey_file = :ssl_client_certkey_file,
+	start_submission_period = :start_submission_period, end_submission_period = :end_submission_period,
+	xml_response_xpath = :xml_response_xpath, json_response_xpath = :json_response_xpath,
+	suspended = :suspended, url_params = :url_params, retry_base_delay = :retry_base_delay,
+	retry_max_delay = :retry_max_delay, retry_multiplier = :retry_multiplier, retry_jitter = :retry_jitter,
+	max_in_flight = :max_in_flight, rate_limit = :rate_limit, rate_period = :rate_period,
+	rate_burst = :rate_burst, circuit_failure_threshold = :circuit_failure_threshold,
+	circuit_probe_interval = :circuit_probe_interval, circuit_probe_url = :circuit_probe_url,
+	default_priority = :default_priority, ssl_ca_bundle_file = :ssl_ca_bundle_file,
+	ssl_insecure = :ssl_insecure, auth_params = :auth_params,
+	json_transforms = :json_transforms, updated = current_timestamp
+WHERE id = :id
+RETURNING *`
+
+// saveServer runs a named insert or update of s and returns the stored server
+func saveServer(db *sqlx.DB, query string, s *serverFields) (Server, error) {
+	srv := Server{}
+	rows, err := sqlx.NamedQuery(db, query, s)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" { // unique_violation
+			return srv, &ValidationError{Errors: []FieldError{{Field: "name", Message: "is already taken"}}}
+		}
+		return srv, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return srv, err
+		}
+		return srv, fmt.Errorf("%w: %d", ErrServerNotFound, s.ID)
+	}
+	if err := rows.StructScan(&srv.s); err != nil {
+		return srv, err
+	}
+	rows.Close()
+	if err := LoadServers(); err != nil {
+		log.Println("Failed to reload servers:", err)
+	}
+	return srv, nil
+}
+
+// CreateServer validates and stores a new server, making it available to the dispatcher
+func CreateServer(db *sqlx.DB, params ServerParams) (Server, error) {
+	s := serverFields{ // the column defaults of the servers table
+		HTTPMethod:              http.MethodPost,
+		ParseResponses:          true,
+		EndOfSubmissionPeriod:   24,
+		CCURLS:                  pq.StringArray{},
+		URLParams:               URLParams{},
+		AuthParams:              AuthParams{},
+		JSONTransforms:          JSONTransforms{},
+		RetryBaseDelay:          int(DefaultRetryPolicy.BaseDelay / time.Second),
+		RetryMaxDelay:           int(DefaultRetryPolicy.MaxDelay / time.Second),
+		RetryMultiplier:         DefaultRetryPolicy.Multiplier,
+		RetryJitter:             DefaultRetryPolicy.Jitter,
+		RatePeriod:              "second",
+		CircuitState:            CircuitClosed,
+		CircuitFailureThreshold: 5,
+		CircuitProbeInterval:    60,
+	}
+	params.apply(&s)
+	if s.UID == "" {
+		s.UID = utils.GetUID()
+	}
+	if err := s.validate(); err != nil {
+		return Server{}, err
+	}
+	return saveServer(db, insertServerSQL, &s)
+}
+
+// UpdateServer applies params to the server with the given id. Only the fields set in
+// params are changed
+func UpdateServer(db *sqlx.DB, id ServerID, params ServerParams) (Server, error) {
+	srv, err := GetServer(db, id)
+	if err != nil {
+		return srv, err
+	}
+	params.apply(&srv.s)
+	if err := srv.s.validate(); err != nil {
+		return Server{}, err
+	}
+	return saveServer(db, updateServerSQL, &srv.s)
+}
+
+// DeleteServer removes the server with the given id along with its allowed sources.
+// Servers still referenced by requests cannot be deleted
+func DeleteServer(db *sqlx.DB, id ServerID) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var inUse bool
+	err = tx.Get(&inUse,
+		"SELECT EXISTS(SELECT 1 FROM requests WHERE source = $1 OR destination = $1)", id)
+	if err != nil {
+		return err
+	}
+	if inUse {
+		return fmt.Errorf("%w: %d", ErrServerInUse, id)
+	}
+	if _, err := tx.Exec("DELETE FROM server_allowed_sources WHERE server_id = $1", id); err != nil {
+		return err
+	}
+	// the server may also be listed as an allowed source of other servers
+	_, err = tx.Exec(`
+		UPDATE server_allowed_sources SET allowed_sources = array_remove(allowed_sources, $1), updated = now()
+		WHERE $1 = ANY(allowed_sources)`, id)
+	if err != nil {
+		return err
+	}
+	res, err := tx.Exec("DELETE FROM servers WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w: %d", ErrServerNotFound, id)
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if err := LoadServers(); err != nil {
+		log.Println("Failed to reload servers:", err)
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// errors returned when validating the source and destination of a request
+var (
+	ErrServerNotFound           = errors.New("server not found")
+	ErrUnknownSource            = errors.New("unknown source")
+	ErrUnknownDestination       = errors.New("unknown destination")
+	ErrSourceNotAllowed         = errors.New("source is not allowed for destination")
+	ErrSameSourceAndDestination = errors.New("source and destination must differ")
+)
+
+// AllowedSource is a server permitted to send requests to a destination server
+type AllowedSource struct {
+	ID   ServerID `db:"id" json:"id"`
+	Name string   `db:"name" json:"name"`
+}
+
+// LookupServerID returns the id of the server referenced by its numeric id or its name
+func LookupServerID(db sqlx.Queryer, ref string) (ServerID, error) {
+	var id ServerID
+	var err error
+	if n, convErr := strconv.ParseInt(ref, 10, 64); convErr == nil {
+		err = sqlx.Get(db, &id, "SELECT id FROM servers WHERE id = $1", n)
+	} else {
+		err = sqlx.Get(db, &id, "SELECT id FROM servers WHERE name = $1", ref)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrServerNotFound, ref)
+	}
+	return id, nil
+}
+
+// IsRouteError returns whether err was caused by an unknown or disallowed source/destination
+func IsRouteError(err error) bool {
+	return errors.Is(err, ErrUnknownSource) || errors.Is(err, ErrUnknownDestination) ||
+		errors.Is(err, ErrSourceNotAllowed)
+}
+
+// checkRoute makes sure both servers exist and that source may send requests to destination
+func checkRoute(db sqlx.Queryer, source, destination int, sourceName, destinationName string) error {
+	if source == 0 {
+		return fmt.Errorf("%w: %q", ErrUnknownSource, sourceName)
+	}
+	if destination == 0 {
+		return fmt.Errorf("%w: %q", ErrUnknownDestination, destinationName)
+	}
+	allowed, err := IsAllowedSource(db, source, destination)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return fmt.Errorf("%w: %s -> %s", ErrSourceNotAllowed, sourceName, destinationName)
+	}
+	return nil
+}
+
+// IsAllowedSource returns whether source is among the allowed sources of destination.
+// Destinations without any allowed sources configured accept no requests, the sources that
+// sent requests before allowed sources were enforced were added by a migration
+func IsAllowedSource(db sqlx.Queryer, source, destination int) (bool, error) {
+	var allowed bool
+	err := sqlx.Get(db, &allowed,
+		"SELECT COALESCE(is_allowed_source($1, $2), FALSE)", source, destination)
+	return allowed, err
+}
+
+// GetAllowedSources returns the servers allowed to send requests to destination
+func GetAllowedSources(db sqlx.Queryer, destination ServerID) ([]AllowedSource, error) {
+	sources := []AllowedSource{}
+	err := sqlx.Select(db, &sources, `
+SELECT s.id, s.name
+FROM servers s, server_allowed_sources a
+WHERE a.server_id = $1 AND s.id = ANY(a.allowed_sources)
+ORDER BY s.name`, destination)
+	return sources, err
+}
+
+// SetAllowedSources replaces the allowed sources of destination
+func SetAllowedSources(db sqlx.Execer, destination ServerID, sources []ServerID) error {
+	ids := make([]int64, 0, len(sources))
+	for _, s := range sources {
+		if s == destination {
+			return ErrSameSourceAndDestination
+		}
+		ids = append(ids, int64(s))
+	}
+	_, err := db.Exec(`
+INSERT INTO server_allowed_sources (server_id, allowed_sources)
+VALUES ($1, $2)
+ON CONFLICT (server_id) DO UPDATE
+SET allowed_sources = EXCLUDED.allowed_sources, updated = now()`, destination, pq.Array(ids))
+	return err
+}
+
+// AddAllowedSource allows source to send requests to destination
+func AddAllowedSource(db sqlx.Execer, destination, source ServerID) error {
+	if source == destination {
+		return ErrSameSourceAndDestination
+	}
+	_, err := db.Exec(`
+INSERT INTO server_allowed_sources (server_id, allowed_sources)
+VALUES ($1, ARRAY[$2::INTEGER])
+ON CONFLICT (server_id) DO UPDATE
+SET allowed_sources = array_append(array_remove(server_allowed_sources.allowed_sources, $2::INTEGER), $2::INTEGER),
+	updated = now()`, destination, source)
+	return err
+}
+
+// RemoveAllowedSource stops source from sending requests to destination
+func RemoveAllowedSource(db sqlx.Execer, destination, source ServerID) error {
+	_, err := db.Exec(`
+UPDATE server_allowed_sources
+SET allowed_sources = array_remove(allowed_sources, $2::INTEGER), updated = now()
+WHERE server_id = $1`, destination, source)
+	return err
+}
@@ -1,21 +1,268 @@
 package models
 
 import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
 	"time"
+
+	"github.com/gcinnovate/integrator/utils"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 )
 
 // User is our user object
 type User struct {
-	ID           int64     `db:"id"`
-	UID          string    `db:"uid" json:"uid"`
-	Username     string    `db:"username"`
-	Password     string    `db:"password"`
-	FirstName    string    `json:"firstname" db:"firstname"`
-	LastName     string    `json:"lastname" db:"lastname"`
-	Email        string    `json:"email" db:"email"`
-	Phone        string    `json:"telephone" db:"telephone"`
-	IsActive     bool      `json:"is_active" db:"is_active"`
-	IsSystemUser bool      `json:"is_system_user" db:"is_system_user"`
-	Created      time.Time `json:"created" db:"created"`
-	Updated      time.Time `json:"updated" db:"updated"`
+	ID           int64      `db:"id" json:"id"`
+	UID          string     `db:"uid" json:"uid"`
+	Role         int64      `db:"user_role" json:"user_role"`
+	RoleName     string     `db:"role_name" json:"role_name"`
+	Username     string     `db:"username" json:"username"`
+	Password     string     `db:"password" json:"-"`
+	FirstName    string     `json:"firstname" db:"firstname"`
+	LastName     string     `json:"lastname" db:"lastname"`
+	Email        string     `json:"email" db:"email"`
+	Phone        string     `json:"telephone" db:"telephone"`
+	IsActive     bool       `json:"is_active" db:"is_active"`
+	IsSystemUser bool       `json:"is_system_user" db:"is_system_user"`
+	LastLogin    *time.Time `json:"last_login" db:"last_login"`
+	Created      time.Time  `json:"created" db:"created"`
+	Updated      time.Time  `json:"updated" db:"updated"`
+}
+
+// errors returned when managing users
+var (
+	ErrUserNotFound       = errors.New("user not found")
+	ErrSystemUser         = errors.New("system users cannot be deleted, deactivated, renamed or moved to another role")
+	ErrSystemUserPassword = errors.New("the password of a system user can only be changed by that user")
+	ErrUserInUse          = errors.New("user is referenced by other records, deactivate it instead")
+)
+
+// minPasswordLength is the shortest password accepted for a user
+const minPasswordLength = 8
+
+// UserParams are the fields of a user set through the API. Fields left out of an
+// update keep their current value
+type UserParams struct {
+	Username  *string `json:"username"`
+	Password  *string `json:"password"`
+	FirstName *string `json:"firstname"`
+	LastName  *string `json:"lastname"`
+	Email     *string `json:"email"`
+	Phone     *string `json:"telephone"`
+	Role      *int64  `json:"user_role"`
+	IsActive  *bool   `json:"is_active"`
+}
+
+const selectUserSQL = `
+SELECT
+	u.id, u.uid, u.user_role, r.name AS role_name, u.username, u.password, u.firstname, u.lastname,
+	COALESCE(u.email, '') AS email, u.telephone, u.is_active, u.is_system_user, u.last_login,
+	u.created, u.updated
+FROM users u
+INNER JOIN user_roles r ON r.id = u.user_role`
+
+// GetUsers returns all the users ordered by username
+func GetUsers(db sqlx.Queryer) ([]User, error) {
+	users := []User{}
+	err := sqlx.Select(db, &users, selectUserSQL+" ORDER BY u.username")
+	return users, err
+}
+
+// GetUser returns the user with the given id
+func GetUser(db sqlx.Queryer, id int64) (User, error) {
+	user := User{}
+	err := sqlx.Get(db, &user, selectUserSQL+" WHERE u.id = $1", id)
+	if err == sql.ErrNoRows {
+		return user, fmt.Errorf("%w: %d", ErrUserNotFound, id)
+	}
+	return user, err
+}
+
+// validPassword adds an error for field when password is too short
+func (fe *fieldErrors) validPassword(field, password string) {
+	if len(password) < minPasswordLength {
+		fe.add(field, fmt.Sprintf("must be at least %d characters", minPasswordLength))
+	}
+}
+
+// apply copies the fields set in p onto u, checking them as it goes
+func (p *UserParams) apply(db sqlx.Queryer, u *User, errs *fieldErrors) {
+	if p.Username != nil {
+		u.Username = strings.TrimSpace(*p.Username)
+	}
+	if p.FirstName != nil {
+		u.FirstName = strings.TrimSpace(*p.FirstName)
+	}
+	if p.LastName != nil {
+		u.LastName = strings.TrimSpace(*p.LastName)
+	}
+	if p.Phone != nil {
+		u.Phone = strings.TrimSpace(*p.Phone)
+	}
+	if p.Email != nil {
+		u.Email = strings.TrimSpace(*p.Email)
+		if u.Email != "" {
+			if _, err := mail.ParseAddress(u.Email); err != nil {
+				errs.add("email", "is not a valid email address")
+			}
+		}
+	}
+	if p.IsActive != nil {
+		u.IsActive = *p.IsActive
+	}
+	if p.Role != nil {
+		u.Role = *p.Role
+		var exists bool
+		if err := sqlx.Get(db, &exists, "SELECT EXISTS(SELECT 1 FROM user_roles WHERE id = $1)", u.Role); err != nil || !exists {
+			errs.add("user_role", "is not a known role")
+		}
+	}
+	errs.required("username", u.Username)
+	errs.required("firstname", u.FirstName)
+	errs.required("lastname", u.LastName)
+}
+
+// uniqueUserError turns a duplicate username into a validation error
+func uniqueUserError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" { // unique_violation
+		return &ValidationError{Errors: []FieldError{{Field: "username", Message: "is already taken"}}}
+	}
+	return err
+}
+
+// CreateUser validates and stores a new user. The password is hashed by the database
+func CreateUser(db *sqlx.DB, params UserParams) (User, error) {
+	u := User{IsActive: true}
+	var errs fieldErrors
+	if params.Role == nil {
+		errs.add("user_role", "is required")
+	}
+	params.apply(db, &u, &errs)
+	if params.Password == nil {
+		errs.add("password", "is required")
+	} else {
+		errs.validPassword("password", *params.Password)
+	}
+	if len(errs) > 0 {
+		return u, &ValidationError{Errors: errs}
+	}
+
+	var id int64
+	err := db.Get(&id, `
+INSERT INTO users (uid, user_role, username, password, firstname, lastname, email, telephone, is_active)
+VALUES ($1, $2, $3, crypt($4, gen_salt('bf')), $5, $6, NULLIF($7, ''), $8, $9)
+RETURNING id`,
+		utils.GetUID(), u.Role, u.Username, *params.Password, u.FirstName, u.LastName, u.Email, u.Phone, u.IsActive)
+	if err != nil {
+		return u, uniqueUserError(err)
+	}
+	return GetUser(db, id)
+}
+
+// UpdateUser applies params to the user with the given id. System users keep their
+// username and role and cannot be deactivated, their password is only changed with
+// ChangeUserPassword
+func UpdateUser(db *sqlx.DB, id int64, params UserParams) (User, error) {
+	u, err := GetUser(db, id)
+	if err != nil {
+		return u, err
+	}
+	if u.IsSystemUser && ((params.Username != nil && strings.TrimSpace(*params.Username) != u.Username) ||
+		(params.Role != nil && *params.Role != u.Role) || (params.IsActive != nil && !*params.IsActive)) {
+		return u, ErrSystemUser
+	}
+	if u.IsSystemUser && params.Password != nil {
+		return u, ErrSystemUserPassword
+	}
+	var errs fieldErrors
+	params.apply(db, &u, &errs)
+	if params.Password != nil {
+		errs.validPassword("password", *params.Password)
+	}
+	if len(errs) > 0 {
+		return u, &ValidationError{Errors: errs}
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return u, err
+	}
+	defer func() { _ = tx.Rollback() }()
+	_, err = tx.Exec(`
+UPDATE users SET
+	user_role = $2, username = $3, firstname = $4, lastname = $5, email = NULLIF($6, ''),
+	telephone = $7, is_active = $8, updated = current_timestamp
+WHERE id = $1`,
+		id, u.Role, u.Username, u.FirstName, u.LastName, u.Email, u.Phone, u.IsActive)
+	if err != nil {
+		return u, uniqueUserError(err)
+	}
+	if params.Password != nil {
+		if err := setUserPassword(tx, id, *params.Password); err != nil {
+			return u, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return u, err
+	}
+	return GetUser(db, id)
+}
+
+// ChangeUserPassword replaces the password of the user with the given id on behalf of the
+// user actor. System users can only change their own password
+func ChangeUserPassword(db *sqlx.DB, id, actor int64, password string) error {
+	u, err := GetUser(db, id)
+	if err != nil {
+		return err
+	}
+	if u.IsSystemUser && actor != id {
+		return ErrSystemUserPassword
+	}
+	return setUserPassword(db, id, password)
+}
+
+// setUserPassword replaces the password of the user with the given id
+func setUserPassword(db sqlx.Execer, id int64, password string) error {
+	var errs fieldErrors
+	errs.validPassword("password", password)
+	if len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
+	res, err := db.Exec(`
+UPDATE users SET password = crypt($2, gen_salt('bf')), updated = current_timestamp
+WHERE id = $1`, id, password)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w: %d", ErrUserNotFound, id)
+	}
+	return nil
+}
+
+// SetUserActive activates or deactivates the user with the given id.
+// System users cannot be deactivated
+func SetUserActive(db *sqlx.DB, id int64, active bool) (User, error) {
+	return UpdateUser(db, id, UserParams{IsActive: &active})
+}
+
+// DeleteUser removes the user with the given id. System users cannot be deleted
+func DeleteUser(db *sqlx.DB, id int64) error {
+	u, err := GetUser(db, id)
+	if err != nil {
+		return err
+	}
+	if u.IsSystemUser {
+		return ErrSystemUser
+	}
+	_, err = db.Exec("DELETE FROM users WHERE id = $1 AND NOT is_system_user", id)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23503" { // foreign_key_violation
+		return fmt.Errorf("%w: %d", ErrUserInUse, id)
+	}
+	return err
 }
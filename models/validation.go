@@ -0,0 +1,373 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+	"time"
+
+	"github.com/gcinnovate/integrator/pages"
+)
+
+// object types understood by the dispatcher
+const (
+	ObjectTypeDataValues      = "DATA_VALUES"
+	ObjectTypeBulkDataValues  = "BULK_DATA_VALUES"
+	ObjectTypeTrackedEntities = "TRACKED_ENTITIES"
+	ObjectTypeEvents          = "EVENTS"
+	ObjectTypeEnrollments     = "ENROLLMENTS"
+	ObjectTypeTrackerFlat     = "TRACKER_FLAT"   // DHIS2 2.40 /api/tracker flat payload
+	ObjectTypeTrackerNested   = "TRACKER_NESTED" // DHIS2 2.40 /api/tracker nested payload
+)
+
+// FieldError describes a problem with one field of a request body
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError is returned when a request body is not valid for its object type
+type ValidationError struct {
+	ObjectType string
+	Errors     []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, fe := range e.Errors {
+		if fe.Field == "" {
+			msgs = append(msgs, fe.Message)
+		} else {
+			msgs = append(msgs, fe.Field+": "+fe.Message)
+		}
+	}
+	if e.ObjectType == "" {
+		return "invalid request body: " + strings.Join(msgs, "; ")
+	}
+	return fmt.Sprintf("invalid %s body: %s", e.ObjectType, strings.Join(msgs, "; "))
+}
+
+// fieldErrors collects field errors while walking a payload
+type fieldErrors []FieldError
+
+func (fe *fieldErrors) add(field, message string) {
+	*fe = append(*fe, FieldError{Field: field, Message: message})
+}
+
+func (fe *fieldErrors) required(field, value string) {
+	if strings.TrimSpace(value) == "" {
+		fe.add(field, "is required")
+	}
+}
+
+// date checks that a required date field holds a DHIS2 date or datetime
+func (fe *fieldErrors) date(field, value string) {
+	if strings.TrimSpace(value) == "" {
+		fe.add(field, "is required")
+		return
+	}
+	if _, err := parseDate(value); err != nil {
+		fe.add(field, "must be a date (2006-01-02) or datetime (2006-01-02T15:04:05.000)")
+	}
+}
+
+func (fe *fieldErrors) notEmpty(field string, length int) {
+	if length == 0 {
+		fe.add(field, "must contain at least one item")
+	}
+}
+
+// dateLayouts are the date and datetime formats sent by DHIS2 and its clients. Fractional
+// seconds are accepted by the layouts with seconds
+var dateLayouts = []string{
+	"2006-01-02",
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	time.RFC3339,
+	"2006-01-02T15:04:05Z0700",
+	"2006-01-02 15:04:05",
+}
+
+// parseDate parses a DHIS2 date or datetime, with or without a zone
+func parseDate(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	var err error
+	for _, layout := range dateLayouts {
+		var t time.Time
+		if t, err = time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, err
+}
+
+// the payloads below hold only the fields that are validated. They are decoded here instead of
+// into the pages types so that dates are kept as strings: DHIS2 sends both dates and datetimes,
+// and a date that does not parse is reported on its own field
+type eventsPayload struct {
+	Events []struct {
+		Program    string            `json:"program"`
+		OrgUnit    string            `json:"orgUnit"`
+		EventDate  string            `json:"eventDate"`
+		DataValues []dataValueFields `json:"dataValues"`
+	} `json:"events"`
+}
+
+type enrollmentsPayload struct {
+	Enrollments []struct {
+		Program        string `json:"program"`
+		OrgUnit        string `json:"orgUnit"`
+		EnrollmentDate string `json:"enrollmentDate"`
+	} `json:"enrollments"`
+}
+
+// trackerPayload is both the flat and the nested payload of /api/tracker
+type trackerPayload struct {
+	TrackedEntities []trackedEntityFields `json:"trackedEntities"`
+	Enrollments     []enrollmentFields    `json:"enrollments"`
+	Events          []eventFields         `json:"events"`
+	Relationships   []struct {
+		RelationshipType string `json:"relationshipType"`
+	} `json:"relationships"`
+}
+
+type trackedEntityFields struct {
+	TrackedEntityType string `json:"trackedEntityType"`
+	OrgUnit           string `json:"orgUnit"`
+	Attributes        []struct {
+		Attribute string `json:"attribute"`
+	} `json:"attributes"`
+	Enrollments []enrollmentFields `json:"enrollments"`
+}
+
+type enrollmentFields struct {
+	TrackedEntity string        `json:"trackedEntity"`
+	Program       string        `json:"program"`
+	OrgUnit       string        `json:"orgUnit"`
+	EnrolledAt    string        `json:"enrolledAt"`
+	Events        []eventFields `json:"events"`
+}
+
+type eventFields struct {
+	ProgramStage string            `json:"programStage"`
+	OrgUnit      string            `json:"orgUnit"`
+	OccurredAt   string            `json:"occurredAt"`
+	DataValues   []dataValueFields `json:"dataValues"`
+}
+
+type dataValueFields struct {
+	DataElement string `json:"dataElement"`
+}
+
+// MediaType returns the media type of a Content-Type header without its parameters
+func MediaType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(contentType))
+	}
+	return mediaType
+}
+
+// ValidateBody checks that body is a valid payload for objectType. JSON bodies are checked
+// against the DHIS2 payload of the object type, XML bodies only have to be well formed
+func ValidateBody(objectType, contentType string, body []byte) error {
+	var errs fieldErrors
+	if len(bytes.TrimSpace(body)) == 0 {
+		errs.add("", "body is required")
+		return &ValidationError{ObjectType: objectType, Errors: errs}
+	}
+
+	switch MediaType(contentType) {
+	case "application/json":
+		errs = validateJSONBody(objectType, body)
+	case "application/xml", "text/xml", "application/adx+xml":
+		dec := xml.NewDecoder(bytes.NewReader(body))
+		for {
+			if _, err := dec.Token(); err != nil {
+				if err != io.EOF {
+					errs.add("", fmt.Sprintf("body is not well formed XML: %v", err))
+				}
+				break
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return &ValidationError{ObjectType: objectType, Errors: errs}
+	}
+	return nil
+}
+
+// decodeJSON unmarshals body into v, reporting syntax and type errors as field errors
+func decodeJSON(body []byte, v interface{}) fieldErrors {
+	var errs fieldErrors
+	err := json.Unmarshal(body, v)
+	if err == nil {
+		return nil
+	}
+	var typeErr *json.UnmarshalTypeError
+	var syntaxErr *json.SyntaxError
+	switch {
+	case errors.As(err, &typeErr):
+		errs.add(typeErr.Field, fmt.Sprintf("expected %s but got %s", typeErr.Type, typeErr.Value))
+	case errors.As(err, &syntaxErr):
+		errs.add("", fmt.Sprintf("body is not valid JSON: %v", err))
+	default:
+		errs.add("", err.Error())
+	}
+	return errs
+}
+
+// validateJSONBody validates the structure of a JSON body for each known object type
+func validateJSONBody(objectType string, body []byte) fieldErrors {
+	switch objectType {
+	case ObjectTypeDataValues:
+		payload := DataValuesRequest{}
+		if errs := decodeJSON(body, &payload); errs != nil {
+			return errs
+		}
+		var errs fieldErrors
+		errs.required("orgUnit", payload.OrgUnit)
+		errs.required("period", payload.Period)
+		errs.notEmpty("dataValues", len(payload.DataValues))
+		for i, dv := range payload.DataValues {
+			errs.required(fmt.Sprintf("dataValues[%d].dataElement", i), dv.DataElement)
+		}
+		return errs
+	case ObjectTypeBulkDataValues:
+		payload := BulkDataValuesRequest{}
+		if errs := decodeJSON(body, &payload); errs != nil {
+			return errs
+		}
+		var errs fieldErrors
+		errs.notEmpty("dataValues", len(payload.DataValues))
+		for i, dv := range payload.DataValues {
+			prefix := fmt.Sprintf("dataValues[%d].", i)
+			errs.required(prefix+"dataElement", dv.DataElement)
+			errs.required(prefix+"period", dv.Period)
+			errs.required(prefix+"orgUnit", dv.OrgUnit)
+		}
+		return errs
+	case ObjectTypeTrackedEntities:
+		payload := pages.TeisPayload{}
+		if errs := decodeJSON(body, &payload); errs != nil {
+			return errs
+		}
+		var errs fieldErrors
+		errs.notEmpty("trackedEntityInstances", len(payload.TrackedEntityInstances))
+		for i, tei := range payload.TrackedEntityInstances {
+			prefix := fmt.Sprintf("trackedEntityInstances[%d].", i)
+			errs.required(prefix+"trackedEntityType", tei.TrackedEntityType)
+			errs.required(prefix+"orgUnit", tei.OrgUnit)
+			for j, attr := range tei.Attributes {
+				errs.required(fmt.Sprintf("%sattributes[%d].attribute", prefix, j), attr.Attribute)
+			}
+		}
+		return errs
+	case ObjectTypeEvents:
+		payload := eventsPayload{}
+		if errs := decodeJSON(body, &payload); errs != nil {
+			return errs
+		}
+		var errs fieldErrors
+		errs.notEmpty("events", len(payload.Events))
+		for i, ev := range payload.Events {
+			prefix := fmt.Sprintf("events[%d].", i)
+			errs.required(prefix+"program", ev.Program)
+			errs.required(prefix+"orgUnit", ev.OrgUnit)
+			errs.date(prefix+"eventDate", ev.EventDate)
+			for j, dv := range ev.DataValues {
+				errs.required(fmt.Sprintf("%sdataValues[%d].dataElement", prefix, j), dv.DataElement)
+			}
+		}
+		return errs
+	case ObjectTypeEnrollments:
+		payload := enrollmentsPayload{}
+		if errs := decodeJSON(body, &payload); errs != nil {
+			return errs
+		}
+		var errs fieldErrors
+		errs.notEmpty("enrollments", len(payload.Enrollments))
+		for i, en := range payload.Enrollments {
+			prefix := fmt.Sprintf("enrollments[%d].", i)
+			errs.required(prefix+"program", en.Program)
+			errs.required(prefix+"orgUnit", en.OrgUnit)
+			errs.date(prefix+"enrollmentDate", en.EnrollmentDate)
+		}
+		return errs
+	case ObjectTypeTrackerFlat:
+		payload := trackerPayload{}
+		if errs := decodeJSON(body, &payload); errs != nil {
+			return errs
+		}
+		var errs fieldErrors
+		if len(payload.TrackedEntities)+len(payload.Enrollments)+
+			len(payload.Events)+len(payload.Relationships) == 0 {
+			errs.add("", "payload must contain trackedEntities, enrollments, events or relationships")
+		}
+		for i, te := range payload.TrackedEntities {
+			validateTrackedEntity(&errs, fmt.Sprintf("trackedEntities[%d].", i), te)
+		}
+		for i, en := range payload.Enrollments {
+			prefix := fmt.Sprintf("enrollments[%d].", i)
+			errs.required(prefix+"trackedEntity", en.TrackedEntity)
+			validateEnrollment(&errs, prefix, en)
+		}
+		for i, ev := range payload.Events {
+			validateEvent(&errs, fmt.Sprintf("events[%d].", i), ev)
+		}
+		for i, rel := range payload.Relationships {
+			errs.required(fmt.Sprintf("relationships[%d].relationshipType", i), rel.RelationshipType)
+		}
+		return errs
+	case ObjectTypeTrackerNested:
+		payload := trackerPayload{}
+		if errs := decodeJSON(body, &payload); errs != nil {
+			return errs
+		}
+		var errs fieldErrors
+		errs.notEmpty("trackedEntities", len(payload.TrackedEntities))
+		for i, te := range payload.TrackedEntities {
+			prefix := fmt.Sprintf("trackedEntities[%d].", i)
+			validateTrackedEntity(&errs, prefix, te)
+			for j, en := range te.Enrollments {
+				enPrefix := fmt.Sprintf("%senrollments[%d].", prefix, j)
+				validateEnrollment(&errs, enPrefix, en)
+				for k, ev := range en.Events {
+					validateEvent(&errs, fmt.Sprintf("%sevents[%d].", enPrefix, k), ev)
+				}
+			}
+		}
+		return errs
+	default:
+		var payload interface{}
+		return decodeJSON(body, &payload)
+	}
+}
+
+func validateTrackedEntity(errs *fieldErrors, prefix string, te trackedEntityFields) {
+	errs.required(prefix+"trackedEntityType", te.TrackedEntityType)
+	errs.required(prefix+"orgUnit", te.OrgUnit)
+	for i, attr := range te.Attributes {
+		errs.required(fmt.Sprintf("%sattributes[%d].attribute", prefix, i), attr.Attribute)
+	}
+}
+
+func validateEnrollment(errs *fieldErrors, prefix string, en enrollmentFields) {
+	errs.required(prefix+"program", en.Program)
+	errs.required(prefix+"orgUnit", en.OrgUnit)
+	errs.date(prefix+"enrolledAt", en.EnrolledAt)
+}
+
+func validateEvent(errs *fieldErrors, prefix string, ev eventFields) {
+	errs.required(prefix+"programStage", ev.ProgramStage)
+	errs.required(prefix+"orgUnit", ev.OrgUnit)
+	errs.date(prefix+"occurredAt", ev.OccurredAt)
+	for i, dv := range ev.DataValues {
+		errs.required(fmt.Sprintf("%sdataValues[%d].dataElement", prefix, i), dv.DataElement)
+	}
+}
@@ -128,6 +128,10 @@ type TrackedEntity struct {
 	StoredBy          string `json:"storedBy,omitempty"`
 	CreatedBy         User   `json:"createdBy,omitempty"`
 	UpdatedBy         User   `json:"updatedBy,omitempty"`
+	// nested objects, only used with the NESTED payload
+	Attributes    []AttributeV2  `json:"attributes,omitempty"`
+	Enrollments   []EnrollmentV2 `json:"enrollments,omitempty"`
+	Relationships []Relationship `json:"relationships,omitempty"`
 }
 
 // EnrollmentV2 .....
@@ -293,13 +297,16 @@ type NestedPayload struct {
 type JobResponse struct {
 	ID           string `json:"id"`
 	ResponseType string `json:"responseType"`
+	JobType      string `json:"jobType"`
 	Location     string `json:"location"`
+	// RelativeNotifierEndpoint is returned instead of Location for jobs other than tracker imports
+	RelativeNotifierEndpoint string `json:"relativeNotifierEndpoint"`
 }
 
 // AsyncResponse ...
 type AsyncResponse struct {
 	HTTPStatus     string      `json:"httpStatus"`
-	HTTPStatusCode string      `json:"httpStatusCode"`
+	HTTPStatusCode int         `json:"httpStatusCode"`
 	Status         string      `json:"status"`
 	Message        string      `json:"message"`
 	Response       JobResponse `json:"response"`
@@ -312,16 +319,16 @@ type TrackerJobImportResponse struct {
 	Category  string    `json:"category"`
 	Time      time.Time `json:"time"`
 	Message   string    `json:"message"`
-	Completed string    `json:"completed"`
+	Completed bool      `json:"completed"`
 	ID        string    `json:"id"`
 }
 
 // ImportSummary ...
 type ImportSummary struct {
-	Status           string      `json:"status"`
-	ValidationReport string      `json:"validationReport"`
-	Stats            ImportStats `json:"stats"`
-	TimingStats      Timers      `json:"timingStats"`
+	Status           string           `json:"status"`
+	ValidationReport ValidationReport `json:"validationReport"`
+	Stats            ImportStats      `json:"stats"`
+	TimingStats      Timers           `json:"timingStats"`
 	BundleReport     struct {
 		TypeReportMap TypeReportMap `json:"typeReportMap"`
 	} `json:"bundleReport"`
@@ -190,6 +190,12 @@ func makeTrackerTab(w fyne.Window) fyne.CanvasObject {
 				return
 			}
 			destURL := queueServer.Text
+			credentials := Credentials{
+				AuthMethod: authMethod.Selected,
+				Username:   username.Text,
+				Password:   password.Text,
+				Token:      token.Text,
+			}
 
 			currentTime := time.Now()
 			_ = startTimeBinding.Set(fmt.Sprintf("Start Time: %s",
@@ -253,7 +259,7 @@ func makeTrackerTab(w fyne.Window) fyne.CanvasObject {
 								//if err != nil {
 								//	log.Println("Error queuing chunk: ", err)
 								//}
-								e := postTrackerPayload(finalURL, payLoad, username.Text, password.Text)
+								e := postTrackerPayload(finalURL, payLoad, credentials)
 								if e != nil {
 									failed++
 								} else {
@@ -275,7 +281,7 @@ func makeTrackerTab(w fyne.Window) fyne.CanvasObject {
 					if len(payLoad) > 0 {
 						// Meaning batch size might have been bigger than available entities
 						fmt.Println("Working on last Batch")
-						e := postTrackerPayload(finalURL, payLoad, username.Text, password.Text)
+						e := postTrackerPayload(finalURL, payLoad, credentials)
 						if e != nil {
 							failed++
 						} else {
@@ -332,7 +338,7 @@ func makeTrackerTab(w fyne.Window) fyne.CanvasObject {
 							if err == nil {
 								log.Println(string(j))
 
-								e := postTrackerPayload(finalURL, payLoad, username.Text, password.Text)
+								e := postTrackerPayload(finalURL, payLoad, credentials)
 								if e != nil {
 									failed++
 								} else {
@@ -353,7 +359,7 @@ func makeTrackerTab(w fyne.Window) fyne.CanvasObject {
 					}
 					if len(payLoad) > 0 {
 						// Meaning batch size might have been bigger than available entities
-						e := postTrackerPayload(finalURL, payLoad, username.Text, password.Text)
+						e := postTrackerPayload(finalURL, payLoad, credentials)
 						if e != nil {
 							failed++
 						} else {
@@ -410,7 +416,7 @@ func makeTrackerTab(w fyne.Window) fyne.CanvasObject {
 							if err == nil {
 								log.Println(string(j))
 
-								e := postTrackerPayload(finalURL, payLoad, username.Text, password.Text)
+								e := postTrackerPayload(finalURL, payLoad, credentials)
 								if e != nil {
 									failed++
 								} else {
@@ -431,7 +437,7 @@ func makeTrackerTab(w fyne.Window) fyne.CanvasObject {
 					}
 					if len(payLoad) > 0 {
 						// Meaning batch size might have been bigger than available entities
-						e := postTrackerPayload(finalURL, payLoad, username.Text, password.Text)
+						e := postTrackerPayload(finalURL, payLoad, credentials)
 						if e != nil {
 							failed++
 						} else {
@@ -2,11 +2,10 @@ package pages
 
 import (
 	"bytes"
-	"crypto/tls"
-	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"fyne.io/fyne/v2"
+	"github.com/gcinnovate/integrator/utils/httpclient"
 	"log"
 	"net/http"
 	"net/url"
@@ -64,10 +63,24 @@ func addExtraParams(baseURL string, extraParams url.Values) (string, error) {
 	return parsedURL.String(), nil
 }
 
+// Credentials are the authentication settings chosen on the tracker tab
+type Credentials struct {
+	AuthMethod string // one of the auth methods listed on the tracker tab
+	Username   string
+	Password   string
+	Token      string
+}
+
+// AuthenticateRequest adds the credentials to a request posted from the tracker tab. The
+// dispatcher sets it to its server authenticators on start, until then Basic auth is sent
+var AuthenticateRequest = func(req *http.Request, credentials Credentials) error {
+	req.SetBasicAuth(credentials.Username, credentials.Password)
+	return nil
+}
+
 // postRequest handles our post requests
 func postRequest(
-	baseUrl string, requestData interface{},
-	username string, password string) (*http.Response, error) {
+	baseUrl string, requestData interface{}, credentials Credentials) (*http.Response, error) {
 	requestBody, err := json.Marshal(requestData)
 	if err != nil {
 		return nil, err
@@ -80,23 +93,11 @@ func postRequest(
 
 	req.Header.Set("Content-Type", "application/json")
 
-	// Add basic authentication
-	auth := username + ":" + password
-	basicAuth := "Basic " + base64.StdEncoding.EncodeToString([]byte(auth))
-	req.Header.Set("Authorization", basicAuth)
-
-	// Create custom transport with TLS settings
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{
-			// Set any necessary TLS settings here
-			// For example, to disable certificate validation:
-			InsecureSkipVerify: true,
-		},
+	if err := AuthenticateRequest(req, credentials); err != nil {
+		return nil, err
 	}
 
-	client := &http.Client{
-		Transport: tr,
-	}
+	client := httpclient.Default()
 
 	resp, err := client.Do(req)
 	if err != nil {
@@ -107,11 +108,11 @@ func postRequest(
 }
 
 // postTEIsPayload ...
-func postTEIsPayload(postURL string, payLoad []TrackedEntityInstance, username, password string) error {
+func postTEIsPayload(postURL string, payLoad []TrackedEntityInstance, credentials Credentials) error {
 
 	var teisPayload = TeisPayload{TrackedEntityInstances: payLoad}
 	// Let's push the payload
-	_, err := postRequest(postURL, teisPayload, username, password)
+	_, err := postRequest(postURL, teisPayload, credentials)
 	if err != nil {
 		log.Println("Error queuing chunk: ", err)
 	}
@@ -119,12 +120,12 @@ func postTEIsPayload(postURL string, payLoad []TrackedEntityInstance, username,
 }
 
 // postTrackerPayload is a generic function to post any tracker payload
-func postTrackerPayload(postURL string, payLoad interface{}, username, password string) error {
+func postTrackerPayload(postURL string, payLoad interface{}, credentials Credentials) error {
 	switch t := payLoad.(type) {
 	case []TrackedEntityInstance:
 		fmt.Printf("Type of payload is %v \n", t)
 		var teisPayload = TeisPayload{TrackedEntityInstances: payLoad.([]TrackedEntityInstance)}
-		_, err := postRequest(postURL, teisPayload, username, password)
+		_, err := postRequest(postURL, teisPayload, credentials)
 		if err != nil {
 			log.Println("Error queuing Tracked Entities chunk: ", err)
 		}
@@ -132,7 +133,7 @@ func postTrackerPayload(postURL string, payLoad interface{}, username, password
 	case []Enrollment:
 		fmt.Printf("Type of payload is %v \n", t)
 		var enrollmentsPayload = EnrollmentsPayload{Enrollments: payLoad.([]Enrollment)}
-		_, err := postRequest(postURL, enrollmentsPayload, username, password)
+		_, err := postRequest(postURL, enrollmentsPayload, credentials)
 		if err != nil {
 			log.Println("Error queuing enrollments chunk: ", err)
 		}
@@ -140,7 +141,7 @@ func postTrackerPayload(postURL string, payLoad interface{}, username, password
 	case []Event:
 		fmt.Printf("Type of payload is %v \n", t)
 		var eventsPayload = EventsPayload{Events: payLoad.([]Event)}
-		_, err := postRequest(postURL, eventsPayload, username, password)
+		_, err := postRequest(postURL, eventsPayload, credentials)
 		if err != nil {
 			log.Println("Error queuing events chunk: ", err)
 		}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gcinnovate/integrator/models"
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+)
+
+// shutdownAbortGrace is how long aborted sends get to roll back or release their requests
+const shutdownAbortGrace = 5 * time.Second
+
+var (
+	// stopping is canceled when the dispatcher shuts down, no more work is claimed after that
+	stopping, stop = context.WithCancel(context.Background())
+	// aborting is canceled when in-flight sends did not finish within the ShutdownTimeout
+	aborting, abort = context.WithCancel(context.Background())
+	shutdownOnce    sync.Once
+)
+
+// recoverRequests returns requests whose lease expired while no dispatcher was running to the queue
+func recoverRequests(db *sqlx.DB) {
+	lease := time.Duration(Dispatcher2Conf.RequestLeaseTime) * time.Second
+	n, err := models.RecoverExpiredLeases(db, lease)
+	if err != nil {
+		log.WithError(err).Error("Failed to recover in progress requests")
+		return
+	}
+	if n > 0 {
+		log.WithField("requests", n).Info("Recovered requests left in progress")
+	}
+}
+
+// shutdownOnSignal shuts the dispatcher down on SIGINT or SIGTERM and then calls quit
+func shutdownOnSignal(api *http.Server, workers *sync.WaitGroup, quit func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	sig := <-signals
+	log.WithField("signal", sig.String()).Info("Received signal")
+	shutdown(api, workers)
+	quit()
+}
+
+// shutdown stops claiming requests and, each within its own ShutdownTimeout, closes the API
+// server and waits for the workers to finish their in-flight requests. Stopping also ends the
+// request event streams so that they do not hold up the API server. Sends still running after
+// the timeout are aborted and their requests released. Requests that could not be released are
+// recovered when their lease expires. Only the first call shuts down, later calls wait for it
+// to complete
+func shutdown(api *http.Server, workers *sync.WaitGroup) {
+	shutdownOnce.Do(func() {
+		log.Info("Shutting down, no more requests will be claimed")
+		stop()
+		timeout := time.Duration(Dispatcher2Conf.ShutdownTimeout) * time.Second
+
+		apiClosed := make(chan struct{})
+		go func() {
+			defer close(apiClosed)
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			if err := api.Shutdown(ctx); err != nil {
+				log.WithError(err).Warn("API server did not shut down in time, closing its connections")
+				_ = api.Close()
+			}
+		}()
+
+		done := make(chan struct{})
+		go func() {
+			workers.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+			log.Info("In-flight requests finished")
+		case <-time.After(timeout):
+			log.WithField("timeout", timeout).Warn("In-flight requests did not finish in time, aborting them")
+			abort()
+			select {
+			case <-done:
+			case <-time.After(shutdownAbortGrace):
+				log.Error("Workers did not stop, their requests will be recovered when the leases expire")
+			}
+		}
+		<-apiClosed
+		log.Info("Shutdown complete")
+	})
+}
+
+// sleep waits for d and returns false if the dispatcher started stopping in the meantime
+func sleep(d time.Duration) bool {
+	select {
+	case <-stopping.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
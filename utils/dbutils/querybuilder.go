@@ -22,11 +22,13 @@ type Field struct {
 	Alias       string // if blank name is taken as is
 }
 
-// Condition is the representation of a condition in a where clause
+// Condition is the representation of a condition in a where clause.
+// Values are passed to the database as bind parameters, never interpolated
 type Condition struct {
 	Field    Field
-	Operator string
-	Value    string
+	Operator string        // =, <>, <, >, <=, >=, IN, LIKE, ILIKE, IS NULL, IS NOT NULL, BETWEEN
+	Values   []interface{} // none for IS [NOT] NULL, two for BETWEEN
+	Or       []Condition   // if set, the condition is true when any of these is true
 }
 
 // Join represents a JOIN in a query
@@ -94,29 +96,59 @@ func OrderByToString(orders []Order) string {
 	return orderByStr.String()
 }
 
-// QueryConditions return the conditions as they appear in the WHERE clause
-func QueryConditions(conditions []Condition) string {
+// QueryConditions return the conditions as they appear in the WHERE clause together with
+// the bind parameters they use. Placeholders are numbered after the parameters in args
+func QueryConditions(conditions []Condition, args []interface{}) (string, []interface{}) {
 	var condStr bytes.Buffer
 
 	for idx, c := range conditions {
-
-		switch c.Field.TablePrefix {
-		case "":
-			fmt.Fprintf(&condStr, "%s %s '%s'",
-				c.Field.Name, c.Operator, c.Value)
-		default:
-			fmt.Fprintf(&condStr, "%s.%s %s '%s'",
-				c.Field.TablePrefix, c.Field.Name, c.Operator, c.Value)
-		}
+		var cond string
+		cond, args = conditionToSQL(c, args)
+		condStr.WriteString(cond)
 		if idx != len(conditions)-1 {
 			fmt.Fprintf(&condStr, `
 	AND `)
+		}
+	}
+	return condStr.String(), args
+}
 
-		} else {
-			condStr.WriteString("")
+// conditionToSQL returns a single condition with numbered placeholders for its values
+func conditionToSQL(c Condition, args []interface{}) (string, []interface{}) {
+	if len(c.Or) > 0 {
+		parts := make([]string, 0, len(c.Or))
+		for _, o := range c.Or {
+			var part string
+			part, args = conditionToSQL(o, args)
+			parts = append(parts, part)
 		}
+		return "(" + strings.Join(parts, " OR ") + ")", args
+	}
+
+	field := c.Field.Name
+	if c.Field.TablePrefix != "" {
+		field = c.Field.TablePrefix + "." + c.Field.Name
+	}
+	placeholder := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	switch c.Operator {
+	case "IS NULL", "IS NOT NULL":
+		return fmt.Sprintf("%s %s", field, c.Operator), args
+	case "IN":
+		placeholders := make([]string, 0, len(c.Values))
+		for _, v := range c.Values {
+			placeholders = append(placeholders, placeholder(v))
+		}
+		return fmt.Sprintf("%s IN (%s)", field, strings.Join(placeholders, ", ")), args
+	case "BETWEEN":
+		return fmt.Sprintf("%s BETWEEN %s AND %s",
+			field, placeholder(c.Values[0]), placeholder(c.Values[1])), args
+	default:
+		return fmt.Sprintf("%s %s %s", field, c.Operator, placeholder(c.Values[0])), args
 	}
-	return condStr.String()
 }
 
 // QueryJoins returns the joins that are part of our query in the QueryBuilder object
@@ -130,59 +162,118 @@ func QueryJoins(joins []Join) string {
 	return joinStr.String()
 }
 
-// ToSQL return the SQL representation of our QueryBuilder struct
-func (q *QueryBuilder) ToSQL(paging bool) string {
+// ToSQL return the SQL representation of our QueryBuilder struct and its bind parameters
+func (q *QueryBuilder) ToSQL(paging bool) (string, []interface{}) {
 	if len(q.Fields) > 0 && len(q.QueryTemplate) > 0 {
 		query := fmt.Sprintf(q.QueryTemplate, FieldsToString(q.Fields),
 			q.Table.Name+" "+q.Table.Alias, QueryJoins(q.Joins))
 		if len(q.Conditions) > 0 {
+			conditions, args := QueryConditions(q.Conditions, nil)
 			var ret string
 			if len(q.OrderBy) > 0 {
 				ret += fmt.Sprintf(query+"WHERE %s ORDER BY %s %s",
-					QueryConditions(q.Conditions), OrderByToString(q.OrderBy),
+					conditions, OrderByToString(q.OrderBy),
 					q.QueryLimitClause(paging))
 			} else {
 				ret += fmt.Sprintf(query+"WHERE %s %s",
-					QueryConditions(q.Conditions), q.QueryLimitClause(paging))
+					conditions, q.QueryLimitClause(paging))
 			}
-			return ret
+			return ret, args
 		}
 		if len(q.OrderBy) > 0 {
 			return fmt.Sprintf(query+" ORDER BY %s %s", OrderByToString(q.OrderBy),
-				q.QueryLimitClause(paging))
+				q.QueryLimitClause(paging)), nil
 		}
-		return fmt.Sprintf(query+" %s ", q.QueryLimitClause(paging))
+		return fmt.Sprintf(query+" %s ", q.QueryLimitClause(paging)), nil
 	}
-	return ""
+	return "", nil
+}
+
+// filterOperators maps the operators accepted in filters to their SQL operators
+var filterOperators = map[string]string{
+	"EQ":      "=",
+	"NE":      "<>",
+	"GT":      ">",
+	"LT":      "<",
+	"GE":      ">=",
+	"LE":      "<=",
+	"IN":      "IN",
+	"LIKE":    "LIKE",
+	"ILIKE":   "ILIKE",
+	"NULL":    "IS NULL",
+	"NNULL":   "IS NOT NULL",
+	"BETWEEN": "BETWEEN",
 }
 
-// QueryFiltersToConditions returns a list of conditions with field, operator and value
-func QueryFiltersToConditions(filters []string, tableAlias string) []Condition {
+// QueryFiltersToConditions returns a list of conditions with field, operator and value.
+// Filters have the form field:OPERATOR:value and are combined with AND. Alternatives
+// separated by | in one filter form an OR group e.g. status:EQ:failed|status:EQ:expired.
+// IN takes a comma separated list, optionally in brackets, BETWEEN takes two comma separated
+// values and NULL/NNULL take no value. Only fields in allowedFields may be filtered on
+func QueryFiltersToConditions(filters []string, allowedFields []string, tableAlias string) ([]Condition, error) {
 	conditions := []Condition{}
 	for _, f := range filters {
-		cond := strings.Split(f, ":")
-		if len(cond) == 3 {
-			var op string
-			switch strings.ToUpper(cond[1]) {
-			case "EQ":
-				op = "="
-			case "GT":
-				op = ">"
-			case "LT":
-				op = "<="
-			case "GE":
-				op = ">="
-			case "LE":
-				op = "<="
-			default:
-				op = "="
+		var group []Condition
+		for _, alternative := range strings.Split(f, "|") {
+			condition, err := filterToCondition(alternative, allowedFields, tableAlias)
+			if err != nil {
+				return nil, err
 			}
-			condition := Condition{
-				Field{cond[0], tableAlias, ""}, op, cond[2]}
-			conditions = append(conditions, condition)
+			group = append(group, condition)
+		}
+		if len(group) == 1 {
+			conditions = append(conditions, group[0])
+		} else {
+			conditions = append(conditions, Condition{Or: group})
+		}
+	}
+	return conditions, nil
+}
+
+// filterToCondition parses a single field:OPERATOR:value filter
+func filterToCondition(filter string, allowedFields []string, tableAlias string) (Condition, error) {
+	parts := strings.SplitN(filter, ":", 3)
+	if len(parts) < 2 {
+		return Condition{}, fmt.Errorf("invalid filter %q, expected field:operator:value", filter)
+	}
+	field, operator := parts[0], strings.ToUpper(parts[1])
+	if field == "*" || !utils.SliceContains(allowedFields, field) {
+		return Condition{}, fmt.Errorf("filtering on field %q is not allowed", field)
+	}
+	op, ok := filterOperators[operator]
+	if !ok {
+		return Condition{}, fmt.Errorf("unknown filter operator %q", parts[1])
+	}
+	condition := Condition{Field: Field{Name: field, TablePrefix: tableAlias}, Operator: op}
+
+	switch op {
+	case "IS NULL", "IS NOT NULL":
+		return condition, nil
+	}
+	if len(parts) != 3 {
+		return Condition{}, fmt.Errorf("filter %q is missing a value", filter)
+	}
+	value := parts[2]
+	switch op {
+	case "IN":
+		for _, v := range strings.Split(strings.Trim(value, "[]"), ",") {
+			condition.Values = append(condition.Values, v)
+		}
+	case "BETWEEN":
+		bounds := strings.Split(strings.Trim(value, "[]"), ",")
+		if len(bounds) != 2 {
+			return Condition{}, fmt.Errorf("BETWEEN filter %q needs two comma separated values", filter)
+		}
+		condition.Values = []interface{}{bounds[0], bounds[1]}
+	case "LIKE", "ILIKE":
+		if !strings.ContainsAny(value, "%_") {
+			value = "%" + value + "%"
 		}
+		condition.Values = []interface{}{value}
+	default:
+		condition.Values = []interface{}{value}
 	}
-	return conditions
+	return condition, nil
 }
 
 // OrderListToOrderBy returns a list of Order objects to add to an sql order by clause
@@ -209,7 +300,7 @@ func OrderListToOrderBy(order []string, tableFields []string, tableAlias string)
 	return orderBys
 }
 
-//QueryLimitClause returns the sql string for the LIMIT clause
+// QueryLimitClause returns the sql string for the LIMIT clause
 func (q *QueryBuilder) QueryLimitClause(paging bool) string {
 	if !paging {
 		return ""
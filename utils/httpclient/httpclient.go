@@ -0,0 +1,87 @@
+// Package httpclient builds the HTTP clients used to call other servers. Clients verify
+// TLS certificates unless a server explicitly opts out, and are cached for reuse
+package httpclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultTimeout bounds a request made with a shared client, including reading the response
+const DefaultTimeout = 5 * time.Minute
+
+// TLSOptions are the TLS settings of the connections to a server
+type TLSOptions struct {
+	CABundleFile string // PEM certificates trusted in addition to the system roots
+	CertKeyFile  string // PEM file with the client certificate and its key, for mutual TLS
+	Insecure     bool   // skip certificate verification, only for testing
+}
+
+type cachedClient struct {
+	opts   TLSOptions
+	client *http.Client
+}
+
+var (
+	clientsMu sync.Mutex
+	clients   = map[string]cachedClient{}
+
+	defaultClient = &http.Client{Timeout: DefaultTimeout, Transport: http.DefaultTransport}
+)
+
+// Default returns the shared client for calls that are not made to a configured server
+func Default() *http.Client { return defaultClient }
+
+// Client returns the client cached under key, building it when it does not exist yet
+// or when opts changed since it was built
+func Client(key string, opts TLSOptions) (*http.Client, error) {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	if cached, ok := clients[key]; ok && cached.opts == opts {
+		return cached.client, nil
+	}
+	config, err := TLSConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	client := &http.Client{Timeout: DefaultTimeout, Transport: transport}
+	if cached, ok := clients[key]; ok {
+		cached.client.CloseIdleConnections()
+	}
+	clients[key] = cachedClient{opts: opts, client: client}
+	return client, nil
+}
+
+// TLSConfig builds the TLS configuration described by opts
+func TLSConfig(opts TLSOptions) (*tls.Config, error) {
+	config := &tls.Config{InsecureSkipVerify: opts.Insecure}
+	if opts.CABundleFile != "" {
+		pem, err := os.ReadFile(opts.CABundleFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("CA bundle %s has no PEM certificates", opts.CABundleFile)
+		}
+		config.RootCAs = pool
+	}
+	if opts.CertKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.CertKeyFile, opts.CertKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
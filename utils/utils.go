@@ -2,10 +2,10 @@ package utils
 
 import (
 	"bytes"
-	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"github.com/gcinnovate/integrator/db"
+	"github.com/gcinnovate/integrator/utils/httpclient"
 	"math/rand"
 	"net/http"
 	"os"
@@ -165,18 +165,7 @@ func postWithPAT(
 	tokenAuth := "ApiToken " + authToken
 	req.Header.Set("Authorization", tokenAuth)
 
-	// Create custom transport with TLS settings
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{
-			// Set any necessary TLS settings here
-			// For example, to disable certificate validation:
-			InsecureSkipVerify: true,
-		},
-	}
-
-	client := &http.Client{
-		Transport: tr,
-	}
+	client := httpclient.Default()
 
 	resp, err := client.Do(req)
 	if err != nil {